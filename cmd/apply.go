@@ -0,0 +1,375 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/plan"
+	"githb.com/Go-routine-4595/stream-ingest/domain/run"
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
+
+	"github.com/spf13/cobra"
+)
+
+// applyCmd handles the "apply" command
+var applyCmd = &cobra.Command{
+	Use:   "apply [plan]",
+	Short: "Apply a plan made by ingest --plan to the database",
+	Args:  cobra.ExactArgs(1), // Expect exactly one argument (plan file)
+	Run: func(cmd *cobra.Command, args []string) {
+		planFile := args[0]
+		fmt.Printf("Applying plan: %s\n", planFile)
+		repo, err := openRepository(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer repo.Close()
+		batch, _ := cmd.Flags().GetBool("batch")
+		policy, err := getConflictPolicy(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		// file and user of the run are the ones of the plan
+		journal, journalDir, err := newJournal(cmd, "", "")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		executeApply(repo, planFile, getWorkers(cmd), batch, policy, journal, journalDir)
+	},
+}
+
+func init() {
+	addWorkersFlag(applyCmd)
+	addBatchFlag(applyCmd)
+	addConflictFlag(applyCmd)
+	rootCmd.AddCommand(applyCmd)
+}
+
+func executeApply(repo repository.StreamRepository, planFile string, workers int, batch bool, policy string, journal *run.Journal, journalDir string) {
+	var logRecs []logRecord
+
+	p, err := plan.Load(planFile)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	logRecs = append(logRecs, logRecord{err: nil, msg: fmt.Sprintf("Plan for file %s made by %s at %s: %s", p.File, p.User, p.CreatedUtc, p.Summary())})
+
+	// the registry must still be in the state it was when the plan was made
+	if !verifyPlan(repo, p, &logRecs) {
+		logRecs = append(logRecs, logRecord{err: nil, msg: "The registry changed since the plan was made, nothing applied: make a new plan"})
+		printLogRecord(logRecs)
+		return
+	}
+	journal.File, journal.User = p.File, p.User
+	applyPlan(repo, p, journal, workers, batch, policy, &logRecs)
+	saveJournal(journal, journalDir, &logRecs)
+	printLogRecord(logRecs)
+	printRepositoryStats(repo)
+}
+
+// verifyPlan checks that no stream targeted by the plan changed since planning:
+// streams to create must still be missing and streams to update must still have the planned ETag.
+func verifyPlan(repo repository.StreamRepository, p *plan.Plan, records *[]logRecord) bool {
+	ok := true
+
+	for _, c := range p.Creates {
+		fetched, err := repo.GetStreamByStreamIdAndSiteCode(c.Stream.SensorID, c.Stream.SiteCode)
+		if err != nil {
+			*records = append(*records, logRecord{err: err, msg: fmt.Sprintf("Failed to get stream %s (line %d)", c.Stream.SensorID, c.Line)})
+			ok = false
+			continue
+		}
+		if len(fetched) > 0 {
+			*records = append(*records, logRecord{err: nil, msg: fmt.Sprintf("Stream %s (line %d) was created in the Registry since planning", c.Stream.SensorID, c.Line)})
+			ok = false
+		}
+	}
+
+	for _, u := range p.Updates {
+		fetched, err := repo.GetStreamByStreamIdAndSiteCode(u.Before.SensorID, u.Before.SiteCode)
+		if err != nil {
+			*records = append(*records, logRecord{err: err, msg: fmt.Sprintf("Failed to get stream %s (line %d)", u.Before.SensorID, u.Line)})
+			ok = false
+			continue
+		}
+		found := false
+		for _, f := range fetched {
+			if f.ID != u.Before.ID {
+				continue
+			}
+			found = true
+			if f.ETag != u.ETag {
+				*records = append(*records, logRecord{err: nil, msg: fmt.Sprintf("Stream %s (line %d) was modified in the Registry since planning", u.Before.SensorID, u.Line)})
+				ok = false
+			}
+		}
+		if !found {
+			*records = append(*records, logRecord{err: nil, msg: fmt.Sprintf("Stream %s (line %d) was removed from the Registry since planning", u.Before.SensorID, u.Line)})
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// Policies for the updates rejected because the registry document changed since it was read
+const (
+	conflictSkip  = "skip"              // report the conflict and go on with the other streams
+	conflictFail  = "fail"              // report the conflict and stop writing updates
+	conflictMerge = "refetch-and-merge" // read the document again and apply the file row on top of it
+)
+
+// maxMergeRounds bounds how many times a stream is read again when it keeps changing
+const maxMergeRounds = 3
+
+func addConflictFlag(cmd *cobra.Command) {
+	cmd.Flags().String("on-conflict", conflictSkip, "What to do when a stream changed in the database since it was read: skip, fail or refetch-and-merge")
+}
+
+func getConflictPolicy(cmd *cobra.Command) (string, error) {
+	policy, _ := cmd.Flags().GetString("on-conflict")
+	switch policy {
+	case conflictSkip, conflictFail, conflictMerge:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid --on-conflict %s: expected %s, %s or %s", policy, conflictSkip, conflictFail, conflictMerge)
+}
+
+// applyPlan writes the creates and then the updates of the plan to the registry, either with
+// the given number of concurrent workers or, when batch is set, with transactional batches.
+// Every stream written carries the run ID of the journal, which records the streams written.
+// Updates are conditioned on the ETag read while planning, the ones rejected because the
+// document changed meanwhile are handled according to policy.
+// Errors are logged with the line of the file the stream comes from.
+// A repository keeping its writes in memory is flushed at the end.
+func applyPlan(repo repository.StreamRepository, p *plan.Plan, journal *run.Journal, workers int, batch bool, policy string, records *[]logRecord) {
+	lines := p.Lines()
+	p.SetRunID(journal.RunID)
+
+	// Now we add our stream in the DB
+	if len(p.Creates) > 0 {
+		var errs []error
+		if batch {
+			errs = repo.WriteBatchedStreamsByStreamKey(repository.BatchCreate, p.CreateStreams())
+			errs = resubmitCreates(repo, p, errs)
+		} else {
+			errs = writeStreams(p.CreateStreams(), workers, repo.CreatStreamsByStreamKey)
+		}
+		if len(errs) > 0 {
+			addItemErrors(records, errs, "failed to create stream", lines)
+		}
+		failed := failedIDs(errs)
+		for _, c := range p.Creates {
+			if !failed[c.Stream.ID] {
+				journal.AddCreated(c.Line, c.Stream)
+			}
+		}
+	}
+	// Now we update stream in the DB, keeping the version each update replaces
+	if len(p.Updates) > 0 {
+		updates := recordHistory(repo, p, workers, records)
+		errs := writeUpdates(repo, updates, workers, batch, policy)
+		if policy != conflictFail {
+			errs = resolveConflicts(repo, p, errs, workers, batch, policy == conflictMerge, records)
+		}
+		var conflicts []error
+		var others []error
+		for _, err := range errs {
+			if errors.Is(err, repository.ErrPreconditionFailed) {
+				conflicts = append(conflicts, err)
+			} else {
+				others = append(others, err)
+			}
+		}
+		if len(conflicts) > 0 {
+			addItemErrors(records, conflicts, "conflict: the Registry was modified since it was read, not updated: stream", lines)
+		}
+		if len(others) > 0 {
+			addItemErrors(records, others, "failed to update stream", lines)
+		}
+		failed := failedIDs(errs)
+		for _, s := range updates {
+			if !failed[s.ID] {
+				journal.AddUpdated(lines[s.ID], s)
+			}
+		}
+	}
+	flushRepository(repo, records)
+}
+
+// failedIDs returns the IDs of the streams of the item errors.
+func failedIDs(errs []error) map[string]bool {
+	failed := make(map[string]bool, len(errs))
+	for _, err := range errs {
+		var itemErr *repository.ItemError
+		if errors.As(err, &itemErr) {
+			failed[itemErr.ID] = true
+		}
+	}
+	return failed
+}
+
+// recordHistory stores the version of the registry each update replaces and returns the streams
+// to update. An update whose history cannot be stored is not applied, so no version is ever lost.
+func recordHistory(repo repository.StreamRepository, p *plan.Plan, workers int, records *[]logRecord) []stream.Stream {
+	befores := make([]stream.Stream, len(p.Updates))
+	lines := make(map[string]int, len(p.Updates))
+	for i, u := range p.Updates {
+		befores[i] = u.Before
+		lines[stream.HistoryID(u.Before.ID, u.Before.Version)] = u.Line
+	}
+
+	errs := writeStreams(befores, workers, func(chunk []stream.Stream) []error {
+		entries := make([]stream.History, len(chunk))
+		for i, s := range chunk {
+			entries[i] = stream.NewHistory(s)
+		}
+		return repo.CreateHistory(entries)
+	})
+	if len(errs) == 0 {
+		return p.UpdateStreams()
+	}
+	addItemErrors(records, errs, "failed to record the history, not updated: stream", lines)
+
+	failed := failedIDs(errs)
+	streams := make([]stream.Stream, 0, len(p.Updates))
+	for _, u := range p.Updates {
+		if !failed[stream.HistoryID(u.Before.ID, u.Before.Version)] {
+			streams = append(streams, u.After)
+		}
+	}
+	return streams
+}
+
+// writeUpdates replaces the streams. With the fail policy no more updates are sent once a conflict is found.
+func writeUpdates(repo repository.StreamRepository, streams []stream.Stream, workers int, batch bool, policy string) []error {
+	if batch {
+		return repo.WriteBatchedStreamsByStreamKey(repository.BatchReplace, streams)
+	}
+	if policy != conflictFail {
+		return writeStreams(streams, workers, repo.UpdateStreamsByStreamKey)
+	}
+
+	var stopped atomic.Bool
+	return writeStreams(streams, workers, func(chunk []stream.Stream) []error {
+		if stopped.Load() {
+			errs := make([]error, len(chunk))
+			for i, s := range chunk {
+				errs[i] = repository.NewItemError(s, 0, errors.New("not updated: stopped after a conflict (--on-conflict=fail)"))
+			}
+			return errs
+		}
+		errs := repo.UpdateStreamsByStreamKey(chunk)
+		for _, err := range errs {
+			if errors.Is(err, repository.ErrPreconditionFailed) {
+				stopped.Store(true)
+			}
+		}
+		return errs
+	})
+}
+
+// resolveConflicts writes again the streams of a batch rolled back because of another stream
+// and, when merge is set, reads again the streams whose update was rejected because they
+// changed, applies the row of the file on top of the new version and writes them again.
+// It returns the errors left.
+func resolveConflicts(repo repository.StreamRepository, p *plan.Plan, errs []error, workers int, batch bool, merge bool, records *[]logRecord) []error {
+	updates := make(map[string]plan.Update, len(p.Updates))
+	for _, u := range p.Updates {
+		updates[u.After.ID] = u
+	}
+
+	for round := 0; round < maxMergeRounds; round++ {
+		var (
+			retry  []stream.Stream
+			others []error
+		)
+		for _, err := range errs {
+			var itemErr *repository.ItemError
+			if !errors.As(err, &itemErr) {
+				others = append(others, err)
+				continue
+			}
+			u, ok := updates[itemErr.ID]
+			switch {
+			case ok && merge && errors.Is(err, repository.ErrPreconditionFailed) && u.Incoming.SensorID != "":
+				merged, ferr := refetchAndMerge(repo, p, u)
+				if ferr != nil {
+					others = append(others, errors.Join(err, ferr))
+					continue
+				}
+				*records = append(*records, logRecord{err: nil, msg: fmt.Sprintf("Stream %s on line: %d was modified in the Registry since it was read, change merged in the new version", u.After.SensorID, u.Line)})
+				retry = append(retry, merged)
+			case ok && batch && itemErr.StatusCode == http.StatusFailedDependency:
+				retry = append(retry, u.After)
+			default:
+				others = append(others, err)
+			}
+		}
+		if len(retry) == 0 {
+			return others
+		}
+		errs = append(others, writeUpdates(repo, retry, workers, batch, conflictSkip)...)
+	}
+	return errs
+}
+
+// resubmitCreates writes again the creates of a batch rolled back because of another stream,
+// without the streams that failed, and returns the errors left.
+func resubmitCreates(repo repository.StreamRepository, p *plan.Plan, errs []error) []error {
+	creates := make(map[string]stream.Stream, len(p.Creates))
+	for _, c := range p.Creates {
+		creates[c.Stream.ID] = c.Stream
+	}
+
+	for round := 0; round < maxMergeRounds; round++ {
+		var (
+			retry  []stream.Stream
+			others []error
+		)
+		for _, err := range errs {
+			var itemErr *repository.ItemError
+			if errors.As(err, &itemErr) && itemErr.StatusCode == http.StatusFailedDependency {
+				if s, ok := creates[itemErr.ID]; ok {
+					retry = append(retry, s)
+					continue
+				}
+			}
+			others = append(others, err)
+		}
+		if len(retry) == 0 {
+			return others
+		}
+		errs = append(others, repo.WriteBatchedStreamsByStreamKey(repository.BatchCreate, retry)...)
+	}
+	return errs
+}
+
+// refetchAndMerge records the current version of the stream of u in the history and returns it
+// with the file row applied again.
+func refetchAndMerge(repo repository.StreamRepository, p *plan.Plan, u plan.Update) (stream.Stream, error) {
+	fetched, err := repo.GetStreamByStreamIdAndSiteCode(u.After.SensorID, u.After.SiteCode)
+	if err != nil {
+		return stream.Stream{}, err
+	}
+	for _, f := range fetched {
+		if f.ID == u.After.ID {
+			if errs := repo.CreateHistory([]stream.History{stream.NewHistory(f)}); len(errs) > 0 {
+				return stream.Stream{}, errors.Join(errs...)
+			}
+			incoming := u.Incoming
+			incoming.Patch = u.Patch
+			updateStream(p, &f, &incoming)
+			f.RunID = p.RunID
+			return f, nil
+		}
+	}
+	return stream.Stream{}, fmt.Errorf("%w: id %s site %s", repository.ErrNotFound, u.After.ID, u.After.SiteCode)
+}
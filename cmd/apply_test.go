@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/plan"
+	"githb.com/Go-routine-4595/stream-ingest/domain/run"
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
+	"githb.com/Go-routine-4595/stream-ingest/repository/dataprocessor"
+	"githb.com/Go-routine-4595/stream-ingest/repository/filestore"
+	"githb.com/Go-routine-4595/stream-ingest/repository/memory"
+)
+
+// testOptions reads the test input files: SensorID, SiteCode, Name, MaxValue and a Color tag.
+var testOptions = dataprocessor.ReaderOptions{Mapping: dataprocessor.Mapping{Columns: []dataprocessor.Column{
+	{Name: "SensorID", Field: "sensorId", Required: true},
+	{Name: "SiteCode", Field: "siteCode", Required: true},
+	{Name: "Name", Field: "streamName"},
+	{Name: "MaxValue", Field: "maxValue"},
+	{Name: "Color", Tag: "Color"},
+}}}
+
+// writeInput writes the CSV input data in a temporary file.
+func writeInput(t *testing.T, data string) string {
+	t.Helper()
+	fileName := filepath.Join(t.TempDir(), "in.csv")
+	if err := os.WriteFile(fileName, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+// makePlan plans the ingest of the CSV data into repo.
+func makePlan(t *testing.T, repo repository.StreamRepository, data string, update bool) (*plan.Plan, string) {
+	t.Helper()
+	planFile := filepath.Join(t.TempDir(), "plan.json")
+	executeIngest(repo, writeInput(t, data), testOptions, update, nil, "me", planFile, 2, false, conflictSkip, run.New("plan", "", "", "memory"), "")
+	p, err := plan.Load(planFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, planFile
+}
+
+// getStream returns the only stream of the registry with sensorID in site S1.
+func getStream(t *testing.T, repo repository.StreamRepository, sensorID string) stream.Stream {
+	t.Helper()
+	fetched, err := repo.GetStreamByStreamIdAndSiteCode(sensorID, "S1")
+	if err != nil || len(fetched) != 1 {
+		t.Fatalf("get %s = %v, %v, want one stream", sensorID, fetched, err)
+	}
+	return fetched[0]
+}
+
+func TestPlanThenApply(t *testing.T) {
+	repo := memory.NewRepository()
+	p, planFile := makePlan(t, repo, "SensorID,SiteCode,Name\nT1,S1,old\n", false)
+	if p.Summary() != "1 to create, 0 to update, 0 skipped duplicates, 0 conflicts" {
+		t.Fatalf("plan: %s", p.Summary())
+	}
+	// planning writes nothing
+	if all, _ := repo.QueryStreams(repository.StreamFilter{}); len(all) != 0 {
+		t.Fatalf("planning wrote %d streams", len(all))
+	}
+	executeApply(repo, planFile, 2, false, conflictSkip, run.New("run-a", "", "", "memory"), "")
+	created := getStream(t, repo, "T1")
+	if created.RunID != "run-a" || created.StreamName != "old" {
+		t.Errorf("created %+v, want run-a and old", created)
+	}
+
+	p, planFile = makePlan(t, repo, "SensorID,SiteCode,Name,Color\nT1,S1,new,red\nT2,S1,two,\nT1,S1,dup,\n", true)
+	if p.Summary() != "1 to create, 1 to update, 1 skipped duplicates, 0 conflicts" {
+		t.Fatalf("plan: %s", p.Summary())
+	}
+	if u := p.Updates[0]; u.ETag != created.ETag || len(u.Changes) != 2 {
+		t.Errorf("update = %+v, want the ETag read and 2 changes", u)
+	}
+	journal := run.New("run-b", "", "", "memory")
+	executeApply(repo, planFile, 2, false, conflictSkip, journal, "")
+	updated := getStream(t, repo, "T1")
+	if updated.RunID != "run-b" || updated.StreamName != "new" || updated.Tags.Value("Color") != "red" || updated.Version != 2 {
+		t.Errorf("updated %+v, want run-b, new, red and version 2", updated)
+	}
+	if getStream(t, repo, "T2").RunID != "run-b" {
+		t.Error("T2 not created by run-b")
+	}
+	if len(journal.Created) != 1 || len(journal.Updated) != 1 || journal.Updated[0].Line != 2 {
+		t.Errorf("journal %+v, want T2 created and T1 updated on line 2", journal)
+	}
+	entries, _ := repo.GetHistory(updated.ID, "S1")
+	if len(entries) != 1 || entries[0].StreamName != "old" {
+		t.Errorf("history = %+v, want the old version", entries)
+	}
+}
+
+func TestApplyStalePlan(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(repo *memory.Repository, s stream.Stream)
+	}{
+		{"modified", func(repo *memory.Repository, s stream.Stream) {
+			s.StreamName = "other"
+			repo.UpdateStreamsByStreamKey([]stream.Stream{s})
+		}},
+		{"removed", func(repo *memory.Repository, s stream.Stream) {
+			repo.DeleteStreamsByStreamKey([]stream.Stream{s})
+		}},
+		{"created", func(repo *memory.Repository, s stream.Stream) {
+			other := stream.NewStream()
+			other.SiteCode, other.SensorID = "S1", "T2"
+			repo.CreatStreamsByStreamKey([]stream.Stream{other})
+		}},
+	}
+	for _, tt := range tests {
+		repo := memory.NewRepository()
+		seed := stream.NewStream()
+		seed.SiteCode, seed.SensorID, seed.StreamName = "S1", "T1", "old"
+		repo.CreatStreamsByStreamKey([]stream.Stream{seed})
+		_, planFile := makePlan(t, repo, "SensorID,SiteCode,Name\nT1,S1,new\nT2,S1,two\n", true)
+
+		fetched, _ := repo.GetStreamByStreamIdAndSiteCode("T1", "S1")
+		tt.change(repo, fetched[0])
+		before, _ := repo.QueryStreams(repository.StreamFilter{})
+
+		journal := run.New("run-a", "", "", "memory")
+		executeApply(repo, planFile, 2, false, conflictSkip, journal, "")
+		after, _ := repo.QueryStreams(repository.StreamFilter{})
+		if len(journal.Created)+len(journal.Updated) != 0 || len(after) != len(before) {
+			t.Errorf("%s: a stale plan was applied: %+v", tt.name, journal)
+		}
+		for _, s := range after {
+			if s.RunID != "" {
+				t.Errorf("%s: stream %s written by the stale plan", tt.name, s.SensorID)
+			}
+		}
+	}
+}
+
+func TestApplyBatchResubmitsCreates(t *testing.T) {
+	repo := memory.NewRepository()
+	p := plan.New("in.csv", "me", false)
+	for i, sensorID := range []string{"T1", "T2", "T3"} {
+		s := stream.NewStream()
+		s.SiteCode, s.SensorID = "S1", sensorID
+		p.AddCreate(i+2, s)
+	}
+	// T2 already exists: its create fails and rolls the batch back
+	repo.CreatStreamsByStreamKey([]stream.Stream{p.Creates[1].Stream})
+
+	journal := run.New("run-a", "", "", "memory")
+	var records []logRecord
+	applyPlan(repo, p, journal, 2, true, conflictSkip, &records)
+	if getStream(t, repo, "T1").RunID != "run-a" || getStream(t, repo, "T3").RunID != "run-a" {
+		t.Error("the creates rolled back with T2 were not written again")
+	}
+	if len(journal.Created) != 2 || len(records) != 1 {
+		t.Errorf("journal %+v, records %+v, want T1 and T3 created and the error of T2", journal, records)
+	}
+}
+
+func TestUpdateDerivedPrecision(t *testing.T) {
+	repo := memory.NewRepository()
+	executeIngest(repo, writeInput(t, "SensorID,SiteCode,MaxValue\nT1,S1,10\n"), testOptions, false, nil, "me", "", 2, false, conflictSkip, run.New("run-a", "", "", "memory"), "")
+
+	// the precision derived from the decimals of MaxValue is not updated without the field
+	executeIngest(repo, writeInput(t, "SensorID,SiteCode,MaxValue\nT1,S1,10.5\n"), testOptions, true, []string{"maxValue"}, "me", "", 2, false, conflictSkip, run.New("run-b", "", "", "memory"), "")
+	if s := getStream(t, repo, "T1"); s.MaxValue != 10.5 || s.Precision != 0 {
+		t.Errorf("updated %v precision %d, want 10.5 and precision 0", s.MaxValue, s.Precision)
+	}
+	executeIngest(repo, writeInput(t, "SensorID,SiteCode,MaxValue\nT1,S1,10.25\n"), testOptions, true, nil, "me", "", 2, false, conflictSkip, run.New("run-c", "", "", "memory"), "")
+	if s := getStream(t, repo, "T1"); s.MaxValue != 10.25 || s.Precision != 2 {
+		t.Errorf("updated %v precision %d, want 10.25 and precision 2", s.MaxValue, s.Precision)
+	}
+}
+
+func TestApplyFlushes(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := filestore.NewRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, planFile := makePlan(t, repo, "SensorID,SiteCode,Name\nT1,S1,one\nT2,S1,two\n", false)
+	executeApply(repo, planFile, 2, false, conflictSkip, run.New("run-a", "", "", "file"), "")
+	// the streams are saved without closing the store
+	reopened, err := filestore.NewRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all, _ := reopened.QueryStreams(repository.StreamFilter{}); len(all) != 2 {
+		t.Errorf("saved %d streams, want 2", len(all))
+	}
+}
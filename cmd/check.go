@@ -1,11 +1,15 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
-	"githb.com/Go-routine-4595/stream-ingest/repository/cosmos"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
 	"github.com/schollz/progressbar/v3"
 	"io"
+	"os"
+	"text/tabwriter"
 
+	"githb.com/Go-routine-4595/stream-ingest/domain/plan"
 	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
 	"githb.com/Go-routine-4595/stream-ingest/repository/dataprocessor"
 
@@ -20,80 +24,142 @@ var checkCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1), // Expect exactly one argument (file)
 	Run: func(cmd *cobra.Command, args []string) {
 		file := args[0]
+		jsonFile, _ := cmd.Flags().GetString("json")
+		update, _ := cmd.Flags().GetBool("update")
+		updateFields, err := getUpdateFields(cmd, update)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
 		fmt.Printf("Checking if data in file %s exists in the database\n", file)
+		options, err := getReaderOptions(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		repo, err := openRepository(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer repo.Close()
 		// Call your logic to check the file contents against the database here
-		executeCheck(file)
+		executeCheck(repo, file, options, update, updateFields, jsonFile, getWorkers(cmd))
 	},
 }
 
 func init() {
+	addWorkersFlag(checkCmd)
+	addInputFlags(checkCmd)
+	checkCmd.Flags().Bool("update", false, "Show the changes of ingest --update, otherwise the tags ingest adds")
+	addUpdateFieldsFlag(checkCmd)
+	checkCmd.Flags().String("json", "", "Also write the differences found as JSON to this file")
 	rootCmd.AddCommand(checkCmd)
 }
 
-func executeCheck(file string) {
+// executeCheck shows the changes ingest would make to the registry streams, from the same update.
+func executeCheck(repo repository.StreamRepository, file string, options dataprocessor.ReaderOptions, update bool, updateFields []string, jsonFile string, workers int) {
 	var (
 		err         error
 		streamRes   *stream.Stream
 		storedSteam []stream.Stream
-		reader      *dataprocessor.CSVReader
-		repo        cosmos.Repository
-		lineNumber  int
+		reader      *dataprocessor.Reader
 		bar         *progressbar.ProgressBar
 		logRecs     []logRecord
-		sensorId    map[string]int
+		diffs       []streamDiff
+		checkPlan   *plan.Plan
 	)
 
-	reader, err = dataprocessor.NewCSVReader(file, "")
+	reader, err = dataprocessor.NewReader(file, "", options)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	logEncoding(reader)
 
 	defer reader.Close()
 
-	repo = cosmos.NewRespository()
-	sensorId = make(map[string]int)
+	checkPlan = plan.New(file, "", update)
+	checkPlan.UpdateFields = updateFields
 
-	lineNumber, err = reader.CountLines()
-	bar = progressBar(lineNumber, "Writing processing file "+file+"...")
+	bar = progressBar(reader.Size(), "Writing processing file "+file+"...")
 	defer bar.Finish()
 
-	// We skip the first line (header)
-	_, _ = reader.ReadNext()
-	for i := 2; ; i++ {
-		bar.Add(1)
-		streamRes, err = reader.ReadNext()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			log.Logger.Err(err).Msg("Failed to read next stream")
-			logRecs = append(logRecs, logRecord{err: err, msg: fmt.Sprintf("Failed to read next stream on line: %d", i)})
+	results := lookupStreams(repo, reader, workers, false, bar)
+	warnReplaced(reader)
+	for _, res := range results {
+		i := res.line
+		streamRes = &res.stream
+		storedSteam = res.fetched
+		if res.readErr != nil {
+			log.Logger.Err(res.readErr).Msg("Failed to read next stream")
+			logRecs = append(logRecs, logRecord{err: res.readErr, msg: fmt.Sprintf("Failed to read next stream on line: %d", i)})
+			continue
 		}
-		// check is a row had the same sensorId we already processed in the file
+		// a row had the same sensorId we already processed in the file
 		// SensorID is the primaryKey
-		if _, ok := sensorId[streamRes.SensorID]; ok {
-			logRecs = append(logRecs, logRecord{err: nil, msg: fmt.Sprintf("Duplicate SensorID on line: %d  and  %d", i, sensorId[streamRes.SensorID])})
+		if res.dupOf > 0 {
+			logRecs = append(logRecs, logRecord{err: nil, msg: fmt.Sprintf("Duplicate SensorID on line: %d  and  %d", i, res.dupOf)})
 			continue
-		} else {
-			sensorId[streamRes.SensorID] = i
 		}
-		// SensorID is the primaryKey
-		storedSteam, err = repo.GetStreamByStreamIdAndSiteCode(streamRes.SensorID, streamRes.SiteCode)
-		if err != nil {
-			logRecs = append(logRecs, logRecord{err: err, msg: "Failed to get stream"})
+		if res.err != nil {
+			logRecs = append(logRecs, logRecord{err: res.err, msg: "Failed to get stream"})
 			continue
 		}
 		if len(storedSteam) == 1 {
-			if !stream.CompareStreams(storedSteam[0], *streamRes) {
+			after := storedSteam[0]
+			after.Tags = after.Tags.Clone()
+			updateStream(checkPlan, &after, streamRes)
+			changes := stream.Diff(storedSteam[0], after)
+			if len(changes) > 0 {
 				logRecs = append(logRecs, logRecord{err: nil, msg: fmt.Sprintf("Registry stream: %s need to be updated by file: %s row line: %d ", storedSteam[0].SensorID, file, i)})
+				diffs = append(diffs, streamDiff{Line: i, SensorID: streamRes.SensorID, SiteCode: streamRes.SiteCode, Changes: changes})
 			}
 
 		}
 		if len(storedSteam) > 1 {
-			logRecs = append(logRecs, logRecord{err: err, msg: fmt.Sprintf("stream %s at line: %d  in file: %s appears more than once in the Registry", streamRes.SensorID, i, file)})
+			logRecs = append(logRecs, logRecord{err: nil, msg: fmt.Sprintf("stream %s at line: %d  in file: %s appears more than once in the Registry", streamRes.SensorID, i, file)})
 		}
 	}
 	fmt.Println("")
 	printLogRecord(logRecs)
+	if len(diffs) > 0 {
+		printDiffTable(os.Stdout, diffs)
+	}
+	if jsonFile != "" {
+		if err = writeDiffJSON(jsonFile, diffs); err != nil {
+			log.Logger.Err(err).Msg("Failed to write differences")
+		}
+	}
+}
+
+// streamDiff holds the changes a row of the file would make to a registry stream
+type streamDiff struct {
+	Line     int                  `json:"line"`
+	SensorID string               `json:"sensorId"`
+	SiteCode string               `json:"siteCode"`
+	Changes  []stream.FieldChange `json:"changes"`
+}
+
+// printDiffTable prints one line per changed field
+func printDiffTable(w io.Writer, diffs []streamDiff) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LINE\tSITE\tSENSOR\tFIELD\tCHANGE\tBEFORE\tAFTER")
+	for _, d := range diffs {
+		for _, c := range d.Changes {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", d.Line, d.SiteCode, d.SensorID, c.Field, c.Kind, c.Before, c.After)
+		}
+	}
+	tw.Flush()
+}
+
+func writeDiffJSON(fileName string, diffs []streamDiff) error {
+	if diffs == nil {
+		diffs = make([]streamDiff, 0)
+	}
+	data, err := json.MarshalIndent(diffs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, data, 0o644)
 }
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// configCmd groups the configuration subcommands
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration used to reach the database",
+}
+
+// configShowCmd handles the "config show" command
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the effective configuration with secrets masked",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Print(cfg)
+	},
+}
+
+// configValidateCmd handles the "config validate" command
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check that the effective configuration is complete",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Print(cfg)
+		errs := cfg.Validate()
+		if len(errs) > 0 {
+			for _, e := range errs {
+				log.Logger.Error().Msg(e.Error())
+			}
+			os.Exit(1)
+		}
+		log.Logger.Info().Msgf("Configuration for environment %s is valid", cfg.Environment)
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configValidateCmd)
+	rootCmd.AddCommand(configCmd)
+}
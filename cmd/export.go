@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"githb.com/Go-routine-4595/stream-ingest/model"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
+	"githb.com/Go-routine-4595/stream-ingest/repository/dataprocessor"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// exportCmd handles the "export" command
+var exportCmd = &cobra.Command{
+	Use:   "export [file]",
+	Short: "Export streams of the database to a file in the ingest format",
+	Args:  cobra.ExactArgs(1), // Expect exactly one argument (file)
+	Run: func(cmd *cobra.Command, args []string) {
+		file := args[0]
+		site, _ := cmd.Flags().GetString("site")
+		process, _ := cmd.Flags().GetString("process")
+		tags, _ := cmd.Flags().GetStringArray("tag")
+
+		filter := repository.StreamFilter{SiteCode: site, Process: process}
+		for _, t := range tags {
+			name, value, ok := strings.Cut(t, "=")
+			if !ok {
+				fmt.Printf("invalid tag filter %s: expected name=value\n", t)
+				return
+			}
+			filter.Tags = append(filter.Tags, model.Tag{Name: name, Value: value})
+		}
+
+		fmt.Printf("Exporting streams to file: %s\n", file)
+		repo, err := openRepository(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer repo.Close()
+		executeExport(repo, file, filter)
+	},
+}
+
+func init() {
+	exportCmd.Flags().String("site", "", "Only export the streams of this site code")
+	exportCmd.Flags().String("process", "", "Only export the streams of this process")
+	exportCmd.Flags().StringArray("tag", nil, "Only export the streams having this tag, as name=value (repeatable)")
+	rootCmd.AddCommand(exportCmd)
+}
+
+func executeExport(repo repository.StreamRepository, file string, filter repository.StreamFilter) {
+	streams, err := repo.QueryStreams(filter)
+	if err != nil {
+		log.Logger.Err(err).Msg("Failed to query streams")
+		return
+	}
+
+	persist, err := dataprocessor.NewCSVPersist(file)
+	if err != nil {
+		log.Logger.Err(err).Msg("Failed to create export file")
+		return
+	}
+	defer persist.Close()
+
+	items := make([]model.Item, len(streams))
+	for i, streamS := range streams {
+		items[i] = streamS.ConvertStreamToItem()
+	}
+	if err = persist.Persist(items); err != nil {
+		log.Logger.Err(err).Msg("Failed to export streams")
+		return
+	}
+	log.Logger.Info().Msgf("%d streams exported to %s", len(items), file)
+}
@@ -1,20 +1,29 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+
+	"githb.com/Go-routine-4595/stream-ingest/repository"
+
 	"github.com/k0kubun/go-ansi"
 	"github.com/rs/zerolog/log"
 	"github.com/schollz/progressbar/v3"
 )
 
 type logRecord struct {
-	err error
-	msg string
+	err  error
+	msg  string
+	warn bool // logged as a warning instead of an error
 }
 
 func printLogRecord(logRcords []logRecord) {
 	if len(logRcords) > 0 {
 		for _, logR := range logRcords {
+			if logR.warn {
+				log.Logger.Warn().Err(logR.err).Msgf("%s", logR.msg)
+				continue
+			}
 			log.Logger.Err(logR.err).Msgf("%s", logR.msg)
 		}
 	}
@@ -26,8 +35,25 @@ func addError(logRecords *[]logRecord, err []error, msg string) {
 	}
 }
 
-func progressBar(total int, text string) *progressbar.ProgressBar {
-	bar := progressbar.NewOptions(total,
+// addItemErrors adds the errors to the records, with the file line of the stream
+// when the error is a repository.ItemError of a stream found in lines.
+func addItemErrors(logRecords *[]logRecord, err []error, msg string, lines map[string]int) {
+	for _, e := range err {
+		var itemErr *repository.ItemError
+		if errors.As(e, &itemErr) {
+			if line, ok := lines[itemErr.ID]; ok {
+				*logRecords = append(*logRecords, logRecord{err: e, msg: fmt.Sprintf("%s %s on line: %d", msg, itemErr.SensorID, line)})
+				continue
+			}
+		}
+		*logRecords = append(*logRecords, logRecord{err: e, msg: msg})
+	}
+}
+
+// progressBar shows the bytes of the input file read, a spinner when its size is unknown (-1).
+func progressBar(total int64, text string) *progressbar.ProgressBar {
+	bar := progressbar.NewOptions64(total,
+		progressbar.OptionShowBytes(true),
 		progressbar.OptionSetWriter(ansi.NewAnsiStdout()), //you should install "github.com/k0kubun/go-ansi"
 		progressbar.OptionEnableColorCodes(true),
 		progressbar.OptionSetWidth(15),
@@ -42,3 +68,31 @@ func progressBar(total int, text string) *progressbar.ProgressBar {
 
 	return bar
 }
+
+// flushRepository saves the writes of a repository keeping them in memory, logs the failures
+// and returns their number.
+func flushRepository(repo repository.StreamRepository, records *[]logRecord) int {
+	flusher, ok := repo.(repository.Flusher)
+	if !ok {
+		return 0
+	}
+	errs := flusher.Flush()
+	for _, err := range errs {
+		*records = append(*records, logRecord{err: err, msg: "Failed to save the registry"})
+	}
+	return len(errs)
+}
+
+// printRepositoryStats logs the requests sent to the repository when it measures them,
+// with one line per operation that had to be retried.
+func printRepositoryStats(repo repository.StreamRepository) {
+	reporter, ok := repo.(repository.StatsReporter)
+	if !ok {
+		return
+	}
+	stats := reporter.Stats()
+	for _, item := range stats.Retried {
+		log.Logger.Warn().Err(item.Err).Msgf("%s %s (site %s) took %d attempts, %.2f RU", item.Op, item.ID, item.SiteCode, item.Attempts, item.RequestCharge)
+	}
+	log.Logger.Info().Msgf("%d operations, %d requests (%d retried operations), %.2f RU consumed", stats.Operations, stats.Attempts, len(stats.Retried), stats.RequestCharge)
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
+
+	"github.com/spf13/cobra"
+)
+
+// historyCmd handles the "history" command
+var historyCmd = &cobra.Command{
+	Use:   "history [sensorId]",
+	Short: "Show the versions of a stream and what changed between them",
+	Args:  cobra.ExactArgs(1), // Expect exactly one argument (sensorId)
+	Run: func(cmd *cobra.Command, args []string) {
+		sensorId := args[0]
+		site, _ := cmd.Flags().GetString("site")
+		repo, err := openRepository(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer repo.Close()
+		executeHistory(repo, sensorId, site)
+	},
+}
+
+func init() {
+	historyCmd.Flags().String("site", "", "Site code of the stream")
+	_ = historyCmd.MarkFlagRequired("site")
+	rootCmd.AddCommand(historyCmd)
+}
+
+func executeHistory(repo repository.StreamRepository, sensorId string, site string) {
+	fetched, err := repo.GetStreamByStreamIdAndSiteCode(sensorId, site)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(fetched) == 0 {
+		fmt.Printf("Stream %s not found in site %s\n", sensorId, site)
+		return
+	}
+
+	for _, current := range fetched {
+		entries, err := repo.GetHistory(current.ID, current.SiteCode)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		versions := make([]stream.Stream, 0, len(entries)+1)
+		for _, entry := range entries {
+			versions = append(versions, entry.Restore())
+		}
+		versions = append(versions, current)
+
+		fmt.Printf("Stream %s (id %s, site %s): %d version(s)\n", current.SensorID, current.ID, current.SiteCode, len(versions))
+		printHistory(os.Stdout, versions)
+	}
+}
+
+// printHistory prints each version with who made it and when, followed by the fields it changed
+// compared to the previous version. The first version lists nothing as it is the baseline.
+func printHistory(w io.Writer, versions []stream.Stream) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tUPDATED\tBY\tFIELD\tCHANGE\tBEFORE\tAFTER")
+	for i, v := range versions {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t\t\t\t\n", v.Version, v.UpdatedUtc, v.UpdatedBy)
+		if i == 0 {
+			continue
+		}
+		for _, c := range stream.Diff(versions[i-1], v) {
+			fmt.Fprintf(tw, "\t\t\t%s\t%s\t%s\t%s\n", c.Field, c.Kind, c.Before, c.After)
+		}
+	}
+	tw.Flush()
+}
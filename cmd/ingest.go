@@ -3,12 +3,14 @@ package cmd
 import (
 	"fmt"
 	"githb.com/Go-routine-4595/stream-ingest/model"
-	"io"
 	"os"
+	"strings"
 	"time"
 
+	"githb.com/Go-routine-4595/stream-ingest/domain/plan"
+	"githb.com/Go-routine-4595/stream-ingest/domain/run"
 	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
-	"githb.com/Go-routine-4595/stream-ingest/repository/cosmos"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
 	"githb.com/Go-routine-4595/stream-ingest/repository/dataprocessor"
 
 	"github.com/rs/zerolog/log"
@@ -25,21 +27,60 @@ var ingestCmd = &cobra.Command{
 		file := args[0]
 		update, _ := cmd.Flags().GetBool("update") // Get the value of the "update" flag
 		user, _ := cmd.Flags().GetString("user")
+		planFile, _ := cmd.Flags().GetString("plan")
+		batch, _ := cmd.Flags().GetBool("batch")
+		policy, err := getConflictPolicy(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		updateFields, err := getUpdateFields(cmd, update)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
 		fmt.Printf("Ingesting data from file: %s\n", file)
-		if update {
+		if planFile != "" {
+			fmt.Printf("Plan flag is set: the changes are written to %s and the database is not modified.\n", planFile)
+		}
+		if update && updateFields != nil {
+			fmt.Printf("Update flag is set: Updating %s of existing data in the database.\n", strings.Join(updateFields, ", "))
+		} else if update {
 			fmt.Println("Update flag is set: Updating existing data in the database.")
 		} else {
 			fmt.Println("Ingesting new data only.")
 		}
+		options, err := getReaderOptions(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		journal, journalDir, err := newJournal(cmd, file, user)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		repo, err := openRepository(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer repo.Close()
 		// Call your logic to ingest the data here
-		executeIngest(file, update, user)
+		executeIngest(repo, file, options, update, updateFields, user, planFile, getWorkers(cmd), batch, policy, journal, journalDir)
 	},
 }
 
 func init() {
 	// Add the "ingest" command and define its flag
-	ingestCmd.Flags().Bool("update", false, "Update existing data in the database")
+	ingestCmd.Flags().Bool("update", false, "Update existing data in the database: the fields the file sets, a cell "+stream.ClearValue+" blanks its field or removes its tags, an empty cell or a missing column keeps it")
+	addUpdateFieldsFlag(ingestCmd)
 	ingestCmd.Flags().StringP("user", "u", "", "employee id")
+	addWorkersFlag(ingestCmd)
+	addBatchFlag(ingestCmd)
+	addConflictFlag(ingestCmd)
+	addInputFlags(ingestCmd)
+	ingestCmd.Flags().String("plan", "", "Write the changes to this plan file instead of the database (see apply)")
 
 	// Mark the "user" flag as required
 	err := ingestCmd.MarkFlagRequired("user")
@@ -50,29 +91,26 @@ func init() {
 	rootCmd.AddCommand(ingestCmd)
 }
 
-func executeIngest(file string, update bool, user string) {
+func executeIngest(repo repository.StreamRepository, file string, options dataprocessor.ReaderOptions, update bool, updateFields []string, user string, planFile string, workers int, batch bool, policy string, journal *run.Journal, journalDir string) {
 	var (
 		err                error
 		newStream          *stream.Stream
 		fetchedStreams     []stream.Stream
 		unprocessedStreams []stream.Stream
-		streamsToCreate    []stream.Stream
-		streamsToUpdate    []stream.Stream
-		reader             *dataprocessor.CSVReader
-		repo               cosmos.Repository
+		ingestPlan         *plan.Plan
+		reader             *dataprocessor.Reader
 		persite            dataprocessor.CSVPersist
-		lineNumber         int
 		bar                *progressbar.ProgressBar
 		LogRecords         []logRecord
-		sensorId           map[string]int
 		resFile            string
 	)
 
-	reader, err = dataprocessor.NewCSVReader(file, user)
+	reader, err = dataprocessor.NewReader(file, user, options)
 	if err != nil {
 		log.Logger.Err(err)
 		return
 	}
+	logEncoding(reader)
 
 	resFile = getFileName("import-result")
 	persite, err = dataprocessor.NewCSVPersist(resFile)
@@ -84,78 +122,61 @@ func executeIngest(file string, update bool, user string) {
 	defer reader.Close()
 	defer persite.Close()
 
-	repo = cosmos.NewRespository()
-
 	unprocessedStreams = make([]stream.Stream, 0)
-	streamsToCreate = make([]stream.Stream, 0)
-	streamsToUpdate = make([]stream.Stream, 0)
-	sensorId = make(map[string]int)
+	ingestPlan = plan.New(file, user, update)
+	ingestPlan.UpdateFields = updateFields
 
-	lineNumber, err = reader.CountLines()
-	bar = progressBar(lineNumber, "Processing file "+file+"...")
+	bar = progressBar(reader.Size(), "Processing file "+file+"...")
 	defer bar.Finish()
 
-	//Skipe the first line (header)
-	_, _ = reader.ReadNext()
-
-	for i := 2; ; i++ {
-		bar.Add(1)
-		newStream, err = reader.ReadNext()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			LogRecords = append(LogRecords, logRecord{err: err, msg: fmt.Sprintf("Failed to read next stream line: %d", i)})
+	results := lookupStreams(repo, reader, workers, true, bar)
+	warnReplaced(reader)
+	for _, res := range results {
+		i := res.line
+		newStream = &res.stream
+		fetchedStreams = res.fetched
+		if res.readErr != nil {
+			LogRecords = append(LogRecords, logRecord{err: res.readErr, msg: fmt.Sprintf("Failed to read next stream line: %d", i)})
+			printLogRecord(LogRecords)
 			return
 		}
-		// check is a row had the same sensorId we already processed in the file
+		// a row had the same sensorId we already processed in the file
 		// SensorID is the primaryKey
-		if _, ok := sensorId[newStream.SensorID]; ok {
-			LogRecords = append(LogRecords, logRecord{err: nil, msg: fmt.Sprintf("Duplicate SensorID on line: %d  and  %d", i, sensorId[newStream.SensorID])})
+		if res.dupOf > 0 {
+			LogRecords = append(LogRecords, logRecord{err: nil, msg: fmt.Sprintf("Duplicate SensorID on line: %d  and  %d", i, res.dupOf)})
+			ingestPlan.AddSkipped(i, *newStream, res.dupOf)
 			continue
-		} else {
-			sensorId[newStream.SensorID] = i
 		}
 		// fetchStreams in DB for the stream we just created, is the stream already existing?
-		fetchedStreams, err = repo.GetStreamByStreamIdAndSiteCode(newStream.SensorID, newStream.SiteCode)
-		if err != nil {
-			LogRecords = append(LogRecords, logRecord{err: err, msg: "Failed to get stream"})
+		if res.err != nil {
+			LogRecords = append(LogRecords, logRecord{err: res.err, msg: "Failed to get stream"})
 			unprocessedStreams = append(unprocessedStreams, *newStream)
+			ingestPlan.AddConflict(i, *newStream, "failed to get stream: "+res.err.Error(), nil)
 			continue
 		}
 		// we found multiple steram with the same SensorID this should not append...
 		if len(fetchedStreams) > 1 {
-			LogRecords = append(LogRecords, logRecord{err: err, msg: fmt.Sprintf("More than one stream found in the Registry for %s at line %d in file %s", newStream.SensorID, i, file)})
+			LogRecords = append(LogRecords, logRecord{err: nil, msg: fmt.Sprintf("More than one stream found in the Registry for %s at line %d in file %s", newStream.SensorID, i, file)})
 			unprocessedStreams = append(unprocessedStreams, *newStream)
+			ingestPlan.AddConflict(i, *newStream, "more than one stream found in the Registry", fetchedStreams)
 			continue
 		}
 
 		// if the stream exists we might need to update it
 		if len(fetchedStreams) == 1 {
-			if !stream.CompareStreams(fetchedStreams[0], *newStream) {
+			// only a real change makes a new version of the stream
+			before := fetchedStreams[0]
+			before.Tags = before.Tags.Clone()
+			updateStream(ingestPlan, &fetchedStreams[0], newStream)
+			if len(stream.Diff(before, fetchedStreams[0])) > 0 {
 				LogRecords = append(LogRecords, logRecord{err: nil, msg: fmt.Sprintf("Registry streamId: %s need to be updated by file: %s row line: %d ", fetchedStreams[0].SensorID, file, i)})
-				updateStream(update, &fetchedStreams[0], newStream, user)
-				streamsToUpdate = append(streamsToUpdate, fetchedStreams[0])
+				ingestPlan.AddUpdate(i, before, fetchedStreams[0], *newStream)
 			}
 			continue
 		}
 		// no stream exist simple we create it
 		if len(fetchedStreams) == 0 {
-			streamsToCreate = append(streamsToCreate, *newStream)
-		}
-	}
-	// Now we add our stream in the DB
-	if len(streamsToCreate) > 0 {
-		errs := repo.CreatStreamsByStreamKey(streamsToCreate)
-		if len(errs) > 0 {
-			addError(&LogRecords, errs, "failed to create stream")
-		}
-	}
-	// Now we update stream in the DB
-	if len(streamsToUpdate) > 0 {
-		errs := repo.UpdateStreamsByStreamKey(streamsToUpdate)
-		if len(errs) > 0 {
-			addError(&LogRecords, errs, "failed to create stream")
+			ingestPlan.AddCreate(i, *newStream)
 		}
 	}
 	// if we have unpocessed stream we need to let know the user
@@ -165,14 +186,30 @@ func executeIngest(file string, update bool, user string) {
 	} else {
 		_ = deleteFile(resFile)
 	}
+	// in plan mode we only save what would be done
+	if planFile != "" {
+		err = ingestPlan.Save(planFile)
+		if err != nil {
+			LogRecords = append(LogRecords, logRecord{err: err, msg: "Failed to save plan"})
+		} else {
+			LogRecords = append(LogRecords, logRecord{err: nil, msg: fmt.Sprintf("Plan saved in %s: %s", planFile, ingestPlan.Summary())})
+		}
+		printLogRecord(LogRecords)
+		return
+	}
+	applyPlan(repo, ingestPlan, journal, workers, batch, policy, &LogRecords)
+	saveJournal(journal, journalDir, &LogRecords)
 	printLogRecord(LogRecords)
+	printRepositoryStats(repo)
 }
 
-func updateStream(update bool, stream1 *stream.Stream, stream2 *stream.Stream, user string) {
-	if update {
-		stream.UpdateStream(stream1, stream2, user)
+// updateStream applies the file row stream2 to the registry stream stream1 as the plan says:
+// its fields and tags with --update, otherwise only its tags.
+func updateStream(p *plan.Plan, stream1 *stream.Stream, stream2 *stream.Stream) {
+	if p.Update {
+		stream.UpdateStream(stream1, stream2, p.User, p.UpdateFields)
 	} else {
-		stream.UpdateTags(stream1, stream2, user)
+		stream.UpdateTags(stream1, stream2, p.User)
 	}
 }
 
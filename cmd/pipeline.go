@@ -0,0 +1,241 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
+	"githb.com/Go-routine-4595/stream-ingest/repository/dataprocessor"
+
+	"github.com/rs/zerolog/log"
+	"github.com/schollz/progressbar/v3"
+	"github.com/spf13/cobra"
+)
+
+// maxReplacedLines is the number of lines listed by the warning about replaced characters
+const maxReplacedLines = 20
+
+// defaultWorkers is the number of concurrent registry requests when --workers is not set
+const defaultWorkers = 8
+
+// writeChunkSize is the number of streams a writer worker sends to the repository at once
+const writeChunkSize = 25
+
+// lookupJob is a row of the file waiting for its registry lookup
+type lookupJob struct {
+	line   int
+	offset int64 // bytes of the file read up to the row
+	stream stream.Stream
+}
+
+// lookupResult is the outcome of a row of the file
+type lookupResult struct {
+	line    int
+	offset  int64 // bytes of the file read up to the row
+	stream  stream.Stream
+	fetched []stream.Stream // streams of the registry with the same SensorID and SiteCode
+	err     error           // lookup error
+	readErr error           // the row could not be read, stream is empty
+	dupOf   int             // line of the first row with the same SensorID, 0 if the row is not a duplicate
+}
+
+func addWorkersFlag(cmd *cobra.Command) {
+	cmd.Flags().Int("workers", defaultWorkers, "Number of concurrent requests to the database")
+}
+
+func addBatchFlag(cmd *cobra.Command) {
+	cmd.Flags().Bool("batch", false, "Write the streams with transactional batches per site (up to 100 streams each)")
+}
+
+func addUpdateFieldsFlag(cmd *cobra.Command) {
+	cmd.Flags().StringSlice("update-fields", nil, "With --update, only update these fields, some of "+strings.Join(stream.UpdatableFields(), ", ")+", and tags or tags.<name> for the tags the rows clear (default all)")
+}
+
+// getUpdateFields returns the fields given by --update-fields, nil for every field.
+func getUpdateFields(cmd *cobra.Command, update bool) ([]string, error) {
+	fields, _ := cmd.Flags().GetStringSlice("update-fields")
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if !update {
+		return nil, fmt.Errorf("--update-fields needs --update")
+	}
+	if err := stream.CheckUpdatable(fields); err != nil {
+		return nil, fmt.Errorf("invalid --update-fields: %w", err)
+	}
+	return fields, nil
+}
+
+func addInputFlags(cmd *cobra.Command) {
+	cmd.Flags().String("mapping", "", "Column mapping file (YAML or JSON) describing the layout of the input file, see mapping.example.yaml")
+	cmd.Flags().String("sheet", "", "Sheet of an Excel (.xlsx) input file, by name or 1 based index (default the first sheet)")
+	cmd.Flags().String("format", "", "Format of the input file: "+strings.Join(dataprocessor.Formats(), ", ")+" (default from the file extension, csv otherwise). The file may be - for stdin, gzip or zstd compressed, or a zip archive of input files")
+	cmd.Flags().StringSlice("allow-tags", nil, "Only ingest as tags the extra columns (matching no column of the mapping) with these headers, * matches any text")
+	cmd.Flags().StringSlice("deny-tags", nil, "Never ingest as tags the extra columns with these headers, * matches any text")
+	cmd.Flags().String("encoding", "", "Encoding of a text input file, such as UTF-8, UTF-16LE, windows-1252 or ISO-8859-1 (default detected)")
+	cmd.Flags().String("decimal-separator", "", `Decimal separator of the numbers of the input file, "." or "," (default guessed from each number: the last of "." and "," or the one used once)`)
+}
+
+// getReaderOptions returns how to read the input file: the column mapping given by --mapping,
+// or the default one, the sheet given by --sheet, the format given by --format, the encoding
+// given by --encoding and the decimal separator given by --decimal-separator. --allow-tags and --deny-tags add to the extra columns of the mapping.
+func getReaderOptions(cmd *cobra.Command) (dataprocessor.ReaderOptions, error) {
+	options := dataprocessor.ReaderOptions{Mapping: dataprocessor.DefaultMapping()}
+	options.Sheet, _ = cmd.Flags().GetString("sheet")
+	options.Format, _ = cmd.Flags().GetString("format")
+	options.Encoding, _ = cmd.Flags().GetString("encoding")
+	options.Decimal, _ = cmd.Flags().GetString("decimal-separator")
+	if options.Decimal != dataprocessor.DecimalAuto && options.Decimal != dataprocessor.DecimalDot && options.Decimal != dataprocessor.DecimalComma {
+		return options, fmt.Errorf("invalid --decimal-separator %q, want %q or %q", options.Decimal, dataprocessor.DecimalDot, dataprocessor.DecimalComma)
+	}
+	if fileName, _ := cmd.Flags().GetString("mapping"); fileName != "" {
+		mapping, err := dataprocessor.LoadMapping(fileName)
+		if err != nil {
+			return options, err
+		}
+		options.Mapping = mapping
+	}
+	allow, _ := cmd.Flags().GetStringSlice("allow-tags")
+	deny, _ := cmd.Flags().GetStringSlice("deny-tags")
+	options.Mapping.Extra.Allow = append(options.Mapping.Extra.Allow, allow...)
+	options.Mapping.Extra.Deny = append(options.Mapping.Extra.Deny, deny...)
+	return options, nil
+}
+
+// logEncoding tells the encoding a text file is decoded from when it is not UTF-8.
+func logEncoding(reader *dataprocessor.Reader) {
+	if enc := reader.Encoding(); enc != "" && enc != dataprocessor.EncodingUTF8 {
+		log.Logger.Info().Msgf("Reading the file as %s, set --encoding if this is wrong", enc)
+	}
+}
+
+// warnReplaced lists the lines where bytes not valid in the encoding of the file were replaced
+// by U+FFFD, names with accents or degree signs are then corrupted.
+func warnReplaced(reader *dataprocessor.Reader) {
+	rows := reader.Replaced()
+	if len(rows) == 0 {
+		return
+	}
+	lines := make([]string, 0, maxReplacedLines)
+	for _, row := range rows {
+		if len(lines) == maxReplacedLines {
+			lines = append(lines, fmt.Sprintf("and %d more", len(rows)-maxReplacedLines))
+			break
+		}
+		lines = append(lines, fmt.Sprint(row))
+	}
+	log.Logger.Warn().Msgf("Characters not valid in %s were replaced on lines: %s, set --encoding if this is not the encoding of the file", reader.Encoding(), strings.Join(lines, ", "))
+}
+
+func getWorkers(cmd *cobra.Command) int {
+	workers, _ := cmd.Flags().GetInt("workers")
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+// lookupStreams reads every row of the file and looks its stream up in the registry with
+// a pool of workers. Rows are read by a single goroutine so a SensorID already seen in the
+// file is reported as a duplicate of its first row, exactly as a sequential read does.
+// The results are returned sorted by line number. When stopOnReadError is set reading stops
+// at the first row that cannot be read.
+func lookupStreams(repo repository.StreamRepository, reader *dataprocessor.Reader, workers int, stopOnReadError bool, bar *progressbar.ProgressBar) []lookupResult {
+	jobs := make(chan lookupJob, workers*2)
+	results := make(chan lookupResult, workers*2)
+
+	// reader
+	go func() {
+		defer close(jobs)
+		sensorId := make(map[string]int)
+		for {
+			newStream, err := reader.ReadNext()
+			i, offset := reader.Row(), reader.Offset()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return
+				}
+				results <- lookupResult{line: i, offset: offset, readErr: err}
+				if stopOnReadError {
+					return
+				}
+				continue
+			}
+			// check is a row had the same sensorId we already processed in the file
+			// SensorID is the primaryKey
+			if first, ok := sensorId[newStream.SensorID]; ok {
+				results <- lookupResult{line: i, offset: offset, stream: *newStream, dupOf: first}
+				continue
+			}
+			sensorId[newStream.SensorID] = i
+			jobs <- lookupJob{line: i, offset: offset, stream: *newStream}
+		}
+	}()
+
+	// lookup workers
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				fetched, err := repo.GetStreamByStreamIdAndSiteCode(job.stream.SensorID, job.stream.SiteCode)
+				results <- lookupResult{line: job.line, offset: job.offset, stream: job.stream, fetched: fetched, err: err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	res := make([]lookupResult, 0)
+	var done int64
+	for r := range results {
+		// rows are looked up out of order, the bar shows the furthest row done
+		if bar != nil && r.offset > done {
+			done = r.offset
+			_ = bar.Set64(done)
+		}
+		res = append(res, r)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].line < res[j].line })
+	return res
+}
+
+// writeStreams sends the streams to write in chunks to a pool of workers and returns every error.
+func writeStreams(streams []stream.Stream, workers int, write func([]stream.Stream) []error) []error {
+	var (
+		errs []error
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+	)
+
+	chunks := make(chan []stream.Stream)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunks {
+				chunkErrs := write(chunk)
+				if len(chunkErrs) > 0 {
+					mu.Lock()
+					errs = append(errs, chunkErrs...)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := 0; i < len(streams); i += writeChunkSize {
+		chunks <- streams[i:min(i+writeChunkSize, len(streams))]
+	}
+	close(chunks)
+	wg.Wait()
+
+	return errs
+}
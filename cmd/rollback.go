@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/run"
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// rollbackCmd handles the "rollback" command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [runId]",
+	Short: "Undo an ingest run: delete the streams it created and restore the ones it updated",
+	Long: `Undo an ingest run recorded in the run journal: the streams created by the run are deleted
+and the streams it updated are restored to the version they had before the run.
+Streams modified after the run are left as they are. With --dry-run the registry is read
+but nothing is written, the changes the rollback would make are printed instead.`,
+	Args: cobra.ExactArgs(1), // Expect exactly one argument (run ID)
+	Run: func(cmd *cobra.Command, args []string) {
+		runID := args[0]
+		user, _ := cmd.Flags().GetString("user")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		dir := getJournalDir(cmd)
+
+		journal, err := run.Load(dir, runID)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		backend, err := journalBackend(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if journal.Backend != backend {
+			fmt.Printf("Run %s was written to %s, not to %s: select the same registry with --backend and --env\n", runID, journal.Backend, backend)
+			return
+		}
+		// a dry run previews the rollback against the real registry, so the repository is opened without it
+		repo, err := openBackend(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer repo.Close()
+		executeRollback(repo, journal, dir, user, getWorkers(cmd), dryRun)
+	},
+}
+
+func init() {
+	rollbackCmd.Flags().StringP("user", "u", "", "employee id")
+	addWorkersFlag(rollbackCmd)
+	err := rollbackCmd.MarkFlagRequired("user")
+	if err != nil {
+		log.Logger.Err(err).Msg("Failed to mark the 'user' flag as required")
+	}
+	rootCmd.AddCommand(rollbackCmd)
+}
+
+// Actions of a rollback on a stream
+const (
+	rollbackDelete  = "delete"
+	rollbackRestore = "restore"
+	rollbackSkip    = "skip"
+)
+
+// rollbackAction is what the rollback does to one stream written by the run.
+type rollbackAction struct {
+	line     int
+	sensorID string
+	siteCode string
+	action   string
+	reason   string               // why the stream is skipped
+	changes  []stream.FieldChange // what a restore changes
+	current  stream.Stream        // stream in the registry, to delete or to replace
+	restored stream.Stream        // version to restore
+}
+
+func executeRollback(repo repository.StreamRepository, journal *run.Journal, dir string, user string, workers int, dryRun bool) {
+	var logRecs []logRecord
+
+	logRecs = append(logRecs, logRecord{err: nil, msg: "Rolling back " + journal.Summary()})
+	if journal.RolledBackUtc != "" {
+		logRecs = append(logRecs, logRecord{err: nil, msg: fmt.Sprintf("Run %s was already rolled back at %s by %s", journal.RunID, journal.RolledBackUtc, journal.RolledBackBy)})
+		printLogRecord(logRecs)
+		return
+	}
+
+	actions, errs := planRollback(repo, journal, user)
+	for _, err := range errs {
+		logRecs = append(logRecs, logRecord{err: err, msg: "Failed to read the Registry"})
+	}
+	if len(errs) > 0 {
+		logRecs = append(logRecs, logRecord{err: nil, msg: "Nothing rolled back"})
+		printLogRecord(logRecs)
+		return
+	}
+	printRollback(os.Stdout, actions)
+	if dryRun {
+		logRecs = append(logRecs, logRecord{err: nil, msg: "Dry run: nothing rolled back"})
+		printLogRecord(logRecs)
+		return
+	}
+
+	var (
+		deletes  []stream.Stream
+		restores []stream.Stream
+		entries  []stream.Stream
+		skipped  int
+	)
+	lines := make(map[string]int, len(actions))
+	for _, a := range actions {
+		switch a.action {
+		case rollbackDelete:
+			lines[a.current.ID] = a.line
+			deletes = append(deletes, a.current)
+		case rollbackRestore:
+			lines[a.current.ID] = a.line
+			entries = append(entries, a.current)
+			restores = append(restores, a.restored)
+		default:
+			skipped++
+		}
+	}
+
+	failed := 0
+	if len(deletes) > 0 {
+		errs := writeStreams(deletes, workers, repo.DeleteStreamsByStreamKey)
+		addItemErrors(&logRecs, errs, "failed to delete stream", lines)
+		failed += len(errs)
+	}
+	if len(restores) > 0 {
+		// the version the rollback replaces is kept like any other update
+		errs := writeStreams(entries, workers, func(chunk []stream.Stream) []error {
+			history := make([]stream.History, len(chunk))
+			for i, s := range chunk {
+				history[i] = stream.NewHistory(s)
+			}
+			return repo.CreateHistory(history)
+		})
+		if len(errs) > 0 {
+			addItemErrors(&logRecs, errs, "failed to record the history, not restored: stream", nil)
+			failed += len(restores)
+		} else {
+			errs = writeStreams(restores, workers, repo.UpdateStreamsByStreamKey)
+			addItemErrors(&logRecs, errs, "failed to restore stream", lines)
+			failed += len(errs)
+		}
+	}
+
+	failed += flushRepository(repo, &logRecs)
+
+	logRecs = append(logRecs, logRecord{err: nil, msg: fmt.Sprintf("Rollback of run %s: %d deleted, %d restored, %d skipped, %d failed",
+		journal.RunID, len(deletes), len(restores), skipped, failed)})
+	if failed == 0 {
+		journal.SetRolledBack(user)
+		if err := journal.Save(dir); err != nil {
+			logRecs = append(logRecs, logRecord{err: err, msg: "Failed to save journal"})
+		}
+	}
+	printLogRecord(logRecs)
+	printRepositoryStats(repo)
+}
+
+// planRollback reads the streams written by the run and decides what to do with each one.
+// A stream is only touched if its current version is still the one written by the run.
+func planRollback(repo repository.StreamRepository, journal *run.Journal, user string) ([]rollbackAction, []error) {
+	var (
+		actions []rollbackAction
+		errs    []error
+	)
+
+	for _, e := range journal.Created {
+		a := rollbackAction{line: e.Line, sensorID: e.SensorID, siteCode: e.SiteCode}
+		current, found, err := findStream(repo, e)
+		switch {
+		case err != nil:
+			errs = append(errs, err)
+			continue
+		case !found:
+			a.action, a.reason = rollbackSkip, "already deleted"
+		case current.RunID != journal.RunID:
+			a.action, a.reason = rollbackSkip, modifiedReason(current)
+		default:
+			a.action, a.current = rollbackDelete, current
+		}
+		actions = append(actions, a)
+	}
+
+	for _, e := range journal.Updated {
+		a := rollbackAction{line: e.Line, sensorID: e.SensorID, siteCode: e.SiteCode}
+		current, found, err := findStream(repo, e)
+		switch {
+		case err != nil:
+			errs = append(errs, err)
+			continue
+		case !found:
+			a.action, a.reason = rollbackSkip, "deleted since the run"
+		case current.RunID != journal.RunID:
+			a.action, a.reason = rollbackSkip, modifiedReason(current)
+		default:
+			previous, ok, err := findVersion(repo, current, current.Version-1)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if !ok {
+				a.action, a.reason = rollbackSkip, fmt.Sprintf("version %d not found in the history", current.Version-1)
+				break
+			}
+			restored := previous.Restore()
+			// the restored version is written by no run: a rollback of an earlier run skips it
+			restored.RunID = ""
+			restored.Version = current.Version + 1
+			restored.ETag = current.ETag
+			restored = restored.SetUpdateBy(user)
+			a.action, a.current, a.restored = rollbackRestore, current, restored
+			a.changes = stream.Diff(current, restored)
+		}
+		actions = append(actions, a)
+	}
+
+	return actions, errs
+}
+
+// findStream returns the stream of the registry recorded by the journal entry.
+func findStream(repo repository.StreamRepository, e run.Entry) (stream.Stream, bool, error) {
+	fetched, err := repo.GetStreamByStreamIdAndSiteCode(e.SensorID, e.SiteCode)
+	if err != nil {
+		return stream.Stream{}, false, err
+	}
+	for _, f := range fetched {
+		if f.ID == e.ID {
+			return f, true, nil
+		}
+	}
+	return stream.Stream{}, false, nil
+}
+
+// findVersion returns the given version of the stream s from its history.
+func findVersion(repo repository.StreamRepository, s stream.Stream, version int) (stream.History, bool, error) {
+	entries, err := repo.GetHistory(s.ID, s.SiteCode)
+	if err != nil {
+		return stream.History{}, false, err
+	}
+	for _, entry := range entries {
+		if entry.Version == version {
+			return entry, true, nil
+		}
+	}
+	return stream.History{}, false, nil
+}
+
+func modifiedReason(s stream.Stream) string {
+	if s.RunID == "" {
+		return fmt.Sprintf("modified since the run by %s at %s", s.UpdatedBy, s.UpdatedUtc)
+	}
+	return fmt.Sprintf("modified since the run by run %s", s.RunID)
+}
+
+// printRollback prints what the rollback does to each stream, with the fields a restore changes.
+func printRollback(w io.Writer, actions []rollbackAction) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LINE\tSITE\tSENSOR\tACTION\tFIELD\tBEFORE\tAFTER")
+	for _, a := range actions {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t\t\n", a.line, a.siteCode, a.sensorID, a.action, a.reason)
+		for _, c := range a.changes {
+			fmt.Fprintf(tw, "\t\t\t\t%s\t%s\t%s\n", c.Field, c.Before, c.After)
+		}
+	}
+	tw.Flush()
+}
+
+// newJournal returns the journal of a new run and the directory to save it in.
+// The directory is empty when the run writes to a throw-away registry and is not journaled.
+func newJournal(cmd *cobra.Command, file string, user string) (*run.Journal, string, error) {
+	backend, err := journalBackend(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+	journal := run.New(run.NewRunID(), file, user, backend)
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	if dryRun || backend == "memory" {
+		return journal, "", nil
+	}
+	return journal, getJournalDir(cmd), nil
+}
+
+// saveJournal saves the journal of a run when dir is set and tells the run ID to use for a rollback.
+func saveJournal(journal *run.Journal, dir string, records *[]logRecord) {
+	if dir == "" {
+		return
+	}
+	if len(journal.Created) == 0 && len(journal.Updated) == 0 {
+		return
+	}
+	if err := journal.Save(dir); err != nil {
+		*records = append(*records, logRecord{err: err, msg: "Failed to save the run journal, this run cannot be rolled back"})
+		return
+	}
+	*records = append(*records, logRecord{err: nil, msg: fmt.Sprintf("Run %s journaled in %s, undo it with: rollback %s", journal.RunID, run.Path(dir, journal.RunID), journal.RunID)})
+}
+
+// journalBackend names the registry selected by the --backend and --env flags, so a run is
+// only rolled back against the registry it was written to.
+func journalBackend(cmd *cobra.Command) (string, error) {
+	backend, _ := cmd.Flags().GetString("backend")
+	if backend != "" && backend != "cosmos" {
+		return backend, nil
+	}
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return "", err
+	}
+	return "cosmos:" + cfg.Environment, nil
+}
+
+// getJournalDir returns the directory of the run journals, by default in the user configuration directory.
+func getJournalDir(cmd *cobra.Command) string {
+	dir, _ := cmd.Flags().GetString("journal-dir")
+	if dir != "" {
+		return dir
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return filepath.Join(".stream-ingest", "runs")
+	}
+	return filepath.Join(configDir, "stream-ingest", "runs")
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"testing"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/run"
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
+	"githb.com/Go-routine-4595/stream-ingest/repository/memory"
+)
+
+// ingestRun ingests the CSV data into repo as the run runID and returns its journal.
+func ingestRun(t *testing.T, repo repository.StreamRepository, data string, update bool, runID string) *run.Journal {
+	t.Helper()
+	journal := run.New(runID, "in.csv", "me", "memory")
+	executeIngest(repo, writeInput(t, data), testOptions, update, nil, "me", "", 2, false, conflictSkip, journal, "")
+	return journal
+}
+
+// actions returns the rollback action of each stream of the journal by sensor ID.
+func actions(t *testing.T, repo repository.StreamRepository, journal *run.Journal) map[string]string {
+	t.Helper()
+	planned, errs := planRollback(repo, journal, "admin")
+	if len(errs) > 0 {
+		t.Fatalf("planRollback: %v", errs)
+	}
+	res := make(map[string]string, len(planned))
+	for _, a := range planned {
+		res[a.sensorID] = a.action
+	}
+	return res
+}
+
+func TestRollback(t *testing.T) {
+	repo := memory.NewRepository()
+	dir := t.TempDir()
+	runA := ingestRun(t, repo, "SensorID,SiteCode,Name\nT1,S1,a\nT3,S1,three\n", false, "run-a")
+	runB := ingestRun(t, repo, "SensorID,SiteCode,Name\nT1,S1,b\nT2,S1,two\n", true, "run-b")
+	if len(runB.Created) != 1 || len(runB.Updated) != 1 {
+		t.Fatalf("run-b journal %+v, want T2 created and T1 updated", runB)
+	}
+
+	if got := actions(t, repo, runA); got["T1"] != rollbackSkip || got["T3"] != rollbackDelete {
+		t.Errorf("rollback of run-a before run-b = %v, want T1 skipped, updated by run-b", got)
+	}
+
+	// a dry run writes nothing
+	executeRollback(repo, runB, dir, "admin", 2, true)
+	if getStream(t, repo, "T1").StreamName != "b" || runB.RolledBackUtc != "" {
+		t.Fatal("the dry run rolled back")
+	}
+
+	executeRollback(repo, runB, dir, "admin", 2, false)
+	restored := getStream(t, repo, "T1")
+	if restored.StreamName != "a" || restored.RunID != "" || restored.Version != 3 || restored.UpdatedBy != "admin" {
+		t.Errorf("restored T1 %+v, want a, no run, version 3, by admin", restored)
+	}
+	if fetched, _ := repo.GetStreamByStreamIdAndSiteCode("T2", "S1"); len(fetched) != 0 {
+		t.Error("T2 created by run-b not deleted")
+	}
+	if entries, _ := repo.GetHistory(restored.ID, "S1"); len(entries) != 2 || entries[1].StreamName != "b" {
+		t.Errorf("history = %+v, want the version of run-b kept", entries)
+	}
+	loaded, err := run.Load(dir, "run-b")
+	if err != nil || loaded.RolledBackBy != "admin" {
+		t.Errorf("saved journal = %+v, %v, want rolled back by admin", loaded, err)
+	}
+
+	// T1 is no longer the version written by run-a
+	if got := actions(t, repo, runA); got["T1"] != rollbackSkip || got["T3"] != rollbackDelete {
+		t.Errorf("rollback of run-a = %v, want T1 skipped and T3 deleted", got)
+	}
+	executeRollback(repo, runA, dir, "admin", 2, false)
+	if getStream(t, repo, "T1").StreamName != "a" {
+		t.Error("the rollback of run-a changed T1")
+	}
+	if fetched, _ := repo.GetStreamByStreamIdAndSiteCode("T3", "S1"); len(fetched) != 0 {
+		t.Error("T3 created by run-a not deleted")
+	}
+
+	// a run is rolled back once
+	if got := actions(t, repo, runB); got["T1"] != rollbackSkip || got["T2"] != rollbackSkip {
+		t.Errorf("second rollback of run-b = %v, want everything skipped", got)
+	}
+	executeRollback(repo, loaded, dir, "other", 2, false)
+	if loaded, _ = run.Load(dir, "run-b"); loaded.RolledBackBy != "admin" {
+		t.Error("run-b rolled back twice")
+	}
+}
+
+func TestRollbackMissingHistory(t *testing.T) {
+	repo := memory.NewRepository()
+	ingestRun(t, repo, "SensorID,SiteCode,Name\nT1,S1,a\n", false, "run-a")
+	// an update whose replaced version is not in the history
+	current := getStream(t, repo, "T1")
+	current.RunID, current.Version = "run-b", 5
+	repo.UpdateStreamsByStreamKey([]stream.Stream{current})
+	runB := run.New("run-b", "in.csv", "me", "memory")
+	runB.AddUpdated(2, current)
+
+	planned, errs := planRollback(repo, runB, "admin")
+	if len(errs) > 0 || len(planned) != 1 || planned[0].action != rollbackSkip || planned[0].reason != "version 4 not found in the history" {
+		t.Errorf("planRollback = %+v, %v, want a skip for the missing version", planned, errs)
+	}
+}
@@ -1,8 +1,17 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"strings"
+
+	"githb.com/Go-routine-4595/stream-ingest/config"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
+	"githb.com/Go-routine-4595/stream-ingest/repository/cosmos"
+	"githb.com/Go-routine-4595/stream-ingest/repository/filestore"
+	"githb.com/Go-routine-4595/stream-ingest/repository/memory"
 
 	"github.com/spf13/cobra"
 )
@@ -12,6 +21,14 @@ var rootCmd = &cobra.Command{
 	Short: "Stream Ingest is a CLI tool for verifying, checking, and ingesting data into your database.",
 }
 
+func init() {
+	rootCmd.PersistentFlags().Bool("dry-run", false, "Use an empty in-memory registry instead of the database")
+	rootCmd.PersistentFlags().String("config", "", "Configuration file (default ./stream-ingest.yaml or ~/.config/stream-ingest/config.yaml)")
+	rootCmd.PersistentFlags().String("env", "", "Configuration environment to use (dev, qa, prod...)")
+	rootCmd.PersistentFlags().String("backend", "cosmos", "Registry backend: cosmos, memory or file:///path/to/dir")
+	rootCmd.PersistentFlags().String("journal-dir", "", "Directory of the run journals used by rollback (default ~/.config/stream-ingest/runs)")
+}
+
 func Execute() {
 	// Execute the CLI
 	if err := rootCmd.Execute(); err != nil {
@@ -19,3 +36,55 @@ func Execute() {
 		os.Exit(1)
 	}
 }
+
+// openRepository returns the registry the commands work against, selected by the --dry-run and --backend flags.
+func openRepository(cmd *cobra.Command) (repository.StreamRepository, error) {
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	if dryRun {
+		fmt.Println("Dry run: using an in-memory registry, the database is not touched.")
+		return memory.NewRepository(), nil
+	}
+	return openBackend(cmd)
+}
+
+// openBackend returns the registry selected by the --backend flag, whatever --dry-run is.
+func openBackend(cmd *cobra.Command) (repository.StreamRepository, error) {
+	backend, _ := cmd.Flags().GetString("backend")
+	switch {
+	case backend == "" || backend == "cosmos":
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			return nil, err
+		}
+		if errs := cfg.Validate(); len(errs) > 0 {
+			return nil, errors.Join(append([]error{fmt.Errorf("invalid configuration for environment %s", cfg.Environment)}, errs...)...)
+		}
+		return cosmos.NewRespository(cfg.Cosmos), nil
+	case backend == "memory":
+		return memory.NewRepository(), nil
+	case strings.HasPrefix(backend, "file://"):
+		u, err := url.Parse(backend)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backend %s: %w", backend, err)
+		}
+		dir := u.Path
+		if u.Host != "" {
+			// file://relative/dir
+			dir = u.Host + u.Path
+		}
+		if dir == "" {
+			return nil, fmt.Errorf("invalid backend %s: missing directory", backend)
+		}
+		fmt.Printf("Using file registry in %s\n", dir)
+		return filestore.NewRepository(dir)
+	default:
+		return nil, fmt.Errorf("unknown backend %s", backend)
+	}
+}
+
+// loadConfig returns the effective configuration selected by the --config and --env flags.
+func loadConfig(cmd *cobra.Command) (config.Config, error) {
+	path, _ := cmd.Flags().GetString("config")
+	env, _ := cmd.Flags().GetString("env")
+	return config.Load(path, env)
+}
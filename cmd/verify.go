@@ -1,11 +1,15 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"github.com/schollz/progressbar/v3"
 	"io"
+	"strings"
 
+	"githb.com/Go-routine-4595/stream-ingest/config"
 	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
 	"githb.com/Go-routine-4595/stream-ingest/repository/dataprocessor"
 
 	"github.com/rs/zerolog/log"
@@ -20,53 +24,88 @@ var verifyCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		file := args[0]
 		fmt.Printf("Verifying syntax of file: %s\n", file)
+		options, err := getReaderOptions(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		validator, err := newValidator(cmd, options.Mapping)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		// the registry is only read to report the new tags
+		var repo repository.StreamRepository
+		if newTags, _ := cmd.Flags().GetBool("new-tags"); newTags {
+			if repo, err = openBackend(cmd); err != nil {
+				fmt.Println(err)
+				return
+			}
+			defer repo.Close()
+		}
 
 		// Call your logic to verify the syntax of the file here
-		executeVerify(file)
+		executeVerify(file, options, validator, repo)
 	},
 }
 
 func init() {
+	addInputFlags(verifyCmd)
+	verifyCmd.Flags().StringSlice("rules", nil, "Severity of validation rules, as ID=error|warning|off or -ID to disable a rule, over the rules of the configuration file")
+	verifyCmd.Long = verifyCmd.Short + ".\n\nEach row is checked against the validation rules:\n" + describeRules()
+	verifyCmd.Flags().Bool("new-tags", false, "Report the tags of the extra columns the registry has never seen (reads the registry)")
 	rootCmd.AddCommand(verifyCmd)
 }
 
-func executeVerify(file string) {
+// executeVerify reads every row of the file and reports the rows that cannot be ingested
+// and the rules of the validator they break.
+// When repo is set, the tags of the extra columns are compared with the tags of the registry.
+func executeVerify(file string, options dataprocessor.ReaderOptions, validator *stream.Validator, repo repository.StreamRepository) {
 	var (
-		err        error
-		streamRes  *stream.Stream
-		reader     *dataprocessor.CSVReader
-		issue      bool
-		lineNumber int
-		bar        *progressbar.ProgressBar
-		logRecs    []logRecord
-		sensorId   map[string]int
+		err       error
+		streamRes *stream.Stream
+		reader    *dataprocessor.Reader
+		issue     bool
+		bar       *progressbar.ProgressBar
+		logRecs   []logRecord
+		sensorId  map[string]int
 	)
 
 	issue = false
-	reader, err = dataprocessor.NewCSVReader(file, "")
+	reader, err = dataprocessor.NewReader(file, "", options)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	logEncoding(reader)
 
 	sensorId = make(map[string]int)
 
 	defer reader.Close()
 
-	lineNumber, err = reader.CountLines()
-	bar = progressBar(lineNumber, "Processing file "+file+"...")
+	bar = progressBar(reader.Size(), "Processing file "+file+"...")
 	defer bar.Finish()
 
-	//we skip the first line (header)
-	_, _ = reader.ReadNext()
-	for i := 2; ; i++ {
-		bar.Add(1)
+	for {
 		streamRes, err = reader.ReadNext()
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			logRecs = append(logRecs, logRecord{err: err, msg: fmt.Sprintf("Failed to read next stream on line: %d", i)})
+			bar.Set64(reader.Offset())
+			logRecs = append(logRecs, logRecord{err: err, msg: fmt.Sprintf("Failed to read next stream on line: %d", reader.Row())})
+			issue = true
+			continue
+		}
+		bar.Set64(reader.Offset())
+		i := reader.Row()
+		violations := validator.Validate(*streamRes)
+		for _, v := range violations {
+			logRecs = append(logRecs, logRecord{err: v, msg: fmt.Sprintf("Rule %s broken by %s on line: %d", v.Rule, streamRes.SensorID, i), warn: v.Severity == stream.SeverityWarning})
+		}
+		if stream.HasError(violations) {
 			issue = true
 		}
 		// check is a row had the same sensorId we already processed in the file
@@ -81,7 +120,102 @@ func executeVerify(file string) {
 		fmt.Println("")
 		log.Logger.Info().Msg("Syntax is valid")
 	}
+	warnReplaced(reader)
+	logExtraColumns(reader)
+	if repo != nil {
+		reportNewTags(repo, reader.ExtraTags())
+	}
 	if len(logRecs) > 0 {
 		printLogRecord(logRecs)
 	}
 }
+
+// logExtraColumns tells which columns matching no column of the mapping are ingested as tags.
+func logExtraColumns(reader *dataprocessor.Reader) {
+	if tags := reader.ExtraTags(); len(tags) > 0 {
+		log.Logger.Info().Msgf("Extra columns ingested as tags: %s", strings.Join(tags, ", "))
+	}
+	if ignored := reader.IgnoredColumns(); len(ignored) > 0 {
+		log.Logger.Info().Msgf("Extra columns ignored: %s", strings.Join(ignored, ", "))
+	}
+}
+
+// reportNewTags warns about the tags of the extra columns no stream of the registry holds,
+// with the tag of the registry differing only by case, whitespace or underscores, likely a typo.
+func reportNewTags(repo repository.StreamRepository, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	known, err := repo.TagNames()
+	if err != nil {
+		log.Logger.Err(err).Msg("Failed to read the tags of the registry")
+		return
+	}
+	names := make(map[string]bool, len(known))
+	similar := make(map[string]string, len(known))
+	for _, name := range known {
+		names[name] = true
+		similar[dataprocessor.NormalizeHeader(name)] = name
+	}
+	var newTags []string
+	for _, tag := range tags {
+		if names[tag] {
+			continue
+		}
+		if name, ok := similar[dataprocessor.NormalizeHeader(tag)]; ok {
+			tag = fmt.Sprintf("%s (the registry has %s)", tag, name)
+		}
+		newTags = append(newTags, tag)
+	}
+	if len(newTags) == 0 {
+		log.Logger.Info().Msg("The registry already knows every tag of the extra columns")
+		return
+	}
+	log.Logger.Warn().Msgf("Tags the registry has never seen: %s", strings.Join(newTags, ", "))
+}
+
+// newValidator returns the validator of the rows: the rules with the severity given by the
+// configuration file, then by --rules. The tags filled by the mapping are known tags.
+func newValidator(cmd *cobra.Command, mapping dataprocessor.Mapping) (*stream.Validator, error) {
+	var tags []string
+	for tag := range mapping.Tags() {
+		tags = append(tags, tag)
+	}
+	validator := stream.NewValidator(tags...)
+
+	// only the rules are used, verify works whatever the environment
+	cfg, err := loadConfig(cmd)
+	if err != nil && !errors.Is(err, config.ErrEnvironmentNotFound) {
+		return nil, err
+	}
+	for id, severity := range cfg.Rules {
+		if err = validator.SetSeverity(id, stream.Severity(severity)); err != nil {
+			return nil, errors.Join(fmt.Errorf("invalid rules in %s", cfg.File), err)
+		}
+	}
+
+	flags, _ := cmd.Flags().GetStringSlice("rules")
+	for _, flag := range flags {
+		id, severity, ok := strings.Cut(flag, "=")
+		if !ok {
+			id, ok = strings.CutPrefix(flag, "-")
+			if !ok {
+				return nil, fmt.Errorf("invalid --rules %s, expected ID=error|warning|off or -ID", flag)
+			}
+			severity = string(stream.SeverityOff)
+		}
+		if err = validator.SetSeverity(id, stream.Severity(severity)); err != nil {
+			return nil, err
+		}
+	}
+	return validator, nil
+}
+
+// describeRules lists the validation rules with their default severity.
+func describeRules() string {
+	var b strings.Builder
+	for _, r := range stream.Rules() {
+		fmt.Fprintf(&b, "  %-16s %-8s %s\n", r.ID, r.Severity, r.Description)
+	}
+	return b.String()
+}
@@ -0,0 +1,219 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables overriding the configuration file.
+const (
+	EnvConfigFile      = "STREAM_INGEST_CONFIG"
+	EnvEnvironment     = "STREAM_INGEST_ENV"
+	EnvCosmosEndpoint  = "STREAM_INGEST_COSMOS_ENDPOINT"
+	EnvCosmosKey       = "STREAM_INGEST_COSMOS_KEY"
+	EnvCosmosDatabase  = "STREAM_INGEST_COSMOS_DATABASE"
+	EnvCosmosContainer = "STREAM_INGEST_COSMOS_CONTAINER"
+)
+
+// ErrEnvironmentNotFound is returned by Load when the file has no environment of the selected name.
+// The settings not specific to an environment, such as Rules, are still loaded.
+var ErrEnvironmentNotFound = errors.New("environment not found")
+
+// DefaultEnvironment is used when neither the flags, the environment nor the file select one.
+const DefaultEnvironment = "dev"
+
+// Cosmos holds the settings needed to reach the registry container.
+type Cosmos struct {
+	Endpoint  string `yaml:"endpoint"`
+	Key       string `yaml:"key"`
+	Database  string `yaml:"database"`
+	Container string `yaml:"container"`
+	Retry     Retry  `yaml:"retry"`
+}
+
+// Retry tunes how throttled (429) and transient (408, 503) Cosmos requests are retried.
+// Zero values select the defaults of the cosmos repository.
+type Retry struct {
+	MaxAttempts int `yaml:"maxAttempts"` // attempts per request, including the first one
+	BaseDelayMs int `yaml:"baseDelayMs"` // first backoff delay, doubled on each retry
+	MaxDelayMs  int `yaml:"maxDelayMs"`  // upper bound of a single backoff delay
+	BudgetMs    int `yaml:"budgetMs"`    // total time a request may spend waiting between attempts
+}
+
+// Environment is one named set of settings (dev, qa, prod...).
+type Environment struct {
+	Cosmos Cosmos `yaml:"cosmos"`
+}
+
+// File is the layout of the YAML configuration file.
+type File struct {
+	Default      string                 `yaml:"default"`
+	Environments map[string]Environment `yaml:"environments"`
+	Rules        map[string]string      `yaml:"rules"` // severity (error, warning or off) of validation rules by ID
+}
+
+// Config is the effective configuration once the file and the environment variables are merged.
+type Config struct {
+	File        string // configuration file used, empty when none was found
+	Environment string // selected environment name
+	Cosmos      Cosmos
+	Rules       map[string]string // severity of validation rules by ID, the default severity for the others
+}
+
+// Load builds the effective configuration.
+// path and env come from the --config and --env flags and may be empty.
+// The file is looked up in path, then $STREAM_INGEST_CONFIG, then ./stream-ingest.yaml
+// and ~/.config/stream-ingest/config.yaml; a missing file is not an error, settings
+// may come from the environment variables only.
+func Load(path string, env string) (Config, error) {
+	var (
+		cfg  Config
+		file File
+	)
+
+	if path == "" {
+		path = os.Getenv(EnvConfigFile)
+	}
+	if path == "" {
+		path = lookupFile()
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, errors.Join(fmt.Errorf("failed to read config file %s", path), err)
+		}
+		if err = yaml.Unmarshal(data, &file); err != nil {
+			return cfg, errors.Join(fmt.Errorf("failed to parse config file %s", path), err)
+		}
+		cfg.File = path
+	}
+
+	if env == "" {
+		env = os.Getenv(EnvEnvironment)
+	}
+	if env == "" {
+		env = file.Default
+	}
+	if env == "" {
+		env = DefaultEnvironment
+	}
+	cfg.Environment = env
+	cfg.Rules = file.Rules
+
+	if selected, ok := file.Environments[env]; ok {
+		cfg.Cosmos = selected.Cosmos
+	} else if len(file.Environments) > 0 {
+		return cfg, fmt.Errorf("%w: %s in %s (available: %s)", ErrEnvironmentNotFound, env, path, strings.Join(file.names(), ", "))
+	}
+
+	override(&cfg.Cosmos.Endpoint, EnvCosmosEndpoint)
+	override(&cfg.Cosmos.Key, EnvCosmosKey)
+	override(&cfg.Cosmos.Database, EnvCosmosDatabase)
+	override(&cfg.Cosmos.Container, EnvCosmosContainer)
+
+	return cfg, nil
+}
+
+// Validate checks that every Cosmos setting is present and well-formed.
+func (c Config) Validate() []error {
+	var errs []error
+
+	if c.Cosmos.Endpoint == "" {
+		errs = append(errs, errors.New("cosmos endpoint is not set"))
+	} else if u, err := url.Parse(c.Cosmos.Endpoint); err != nil || u.Scheme != "https" || u.Host == "" {
+		errs = append(errs, fmt.Errorf("cosmos endpoint %s is not an https URL", c.Cosmos.Endpoint))
+	}
+	if c.Cosmos.Key == "" {
+		errs = append(errs, errors.New("cosmos key is not set"))
+	} else if _, err := base64.StdEncoding.DecodeString(c.Cosmos.Key); err != nil {
+		errs = append(errs, errors.New("cosmos key is not valid base64"))
+	}
+	if c.Cosmos.Database == "" {
+		errs = append(errs, errors.New("cosmos database is not set"))
+	}
+	if c.Cosmos.Container == "" {
+		errs = append(errs, errors.New("cosmos container is not set"))
+	}
+	if r := c.Cosmos.Retry; r.MaxAttempts < 0 || r.BaseDelayMs < 0 || r.MaxDelayMs < 0 || r.BudgetMs < 0 {
+		errs = append(errs, errors.New("cosmos retry settings cannot be negative"))
+	}
+
+	return errs
+}
+
+// String prints the effective settings with the secrets masked.
+func (c Config) String() string {
+	file := c.File
+	if file == "" {
+		file = "(none, environment variables only)"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "config file:      %s\n", file)
+	fmt.Fprintf(&b, "environment:      %s\n", c.Environment)
+	fmt.Fprintf(&b, "cosmos endpoint:  %s\n", c.Cosmos.Endpoint)
+	fmt.Fprintf(&b, "cosmos key:       %s\n", Mask(c.Cosmos.Key))
+	fmt.Fprintf(&b, "cosmos database:  %s\n", c.Cosmos.Database)
+	fmt.Fprintf(&b, "cosmos container: %s\n", c.Cosmos.Container)
+	fmt.Fprintf(&b, "cosmos retry:     maxAttempts=%d baseDelayMs=%d maxDelayMs=%d budgetMs=%d (0 = default)\n",
+		c.Cosmos.Retry.MaxAttempts, c.Cosmos.Retry.BaseDelayMs, c.Cosmos.Retry.MaxDelayMs, c.Cosmos.Retry.BudgetMs)
+	if len(c.Rules) > 0 {
+		ids := make([]string, 0, len(c.Rules))
+		for id := range c.Rules {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for i, id := range ids {
+			ids[i] = id + "=" + c.Rules[id]
+		}
+		fmt.Fprintf(&b, "rules:            %s\n", strings.Join(ids, " "))
+	}
+	return b.String()
+}
+
+// Mask hides a secret, keeping only its last 4 characters when it is long enough.
+func Mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) <= 8 {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", 8) + secret[len(secret)-4:]
+}
+
+func (f File) names() []string {
+	names := make([]string, 0, len(f.Environments))
+	for name := range f.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func override(field *string, name string) {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		*field = v
+	}
+}
+
+func lookupFile() string {
+	candidates := []string{"stream-ingest.yaml"}
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".config", "stream-ingest", "config.yaml"))
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testFile = `default: qa
+environments:
+  qa:
+    cosmos:
+      endpoint: https://qa.documents.azure.com:443/
+      key: cWEta2V5LXZhbHVlLTEyMzQ=
+      database: registry
+      container: streams
+      retry:
+        maxAttempts: 3
+  prod:
+    cosmos:
+      endpoint: https://prod.documents.azure.com:443/
+      key: cHJvZC1rZXk=
+      database: registry
+      container: streams
+rules:
+  value-range: warning
+`
+
+// clearEnv unsets the environment variables read by Load for the test.
+func clearEnv(t *testing.T) {
+	for _, name := range []string{EnvConfigFile, EnvEnvironment, EnvCosmosEndpoint, EnvCosmosKey, EnvCosmosDatabase, EnvCosmosContainer} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("HOME", t.TempDir())
+}
+
+func writeFile(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeFile(t, testFile)
+	tests := []struct {
+		name      string
+		path      string
+		env       string
+		vars      map[string]string
+		wantEnv   string
+		wantHost  string
+		wantKey   string
+		wantNoEnv bool
+	}{
+		{name: "file default", path: path, wantEnv: "qa", wantHost: "qa.documents", wantKey: "cWEta2V5LXZhbHVlLTEyMzQ="},
+		{name: "flag", path: path, env: "prod", wantEnv: "prod", wantHost: "prod.documents", wantKey: "cHJvZC1rZXk="},
+		{name: "variable", path: path, vars: map[string]string{EnvEnvironment: "prod"}, wantEnv: "prod", wantHost: "prod.documents", wantKey: "cHJvZC1rZXk="},
+		{name: "flag over variable", path: path, env: "qa", vars: map[string]string{EnvEnvironment: "prod"}, wantEnv: "qa", wantHost: "qa.documents", wantKey: "cWEta2V5LXZhbHVlLTEyMzQ="},
+		{name: "file from variable", vars: map[string]string{EnvConfigFile: path}, wantEnv: "qa", wantHost: "qa.documents", wantKey: "cWEta2V5LXZhbHVlLTEyMzQ="},
+		{name: "key override", path: path, vars: map[string]string{EnvCosmosKey: "b3ZlcnJpZGU="}, wantEnv: "qa", wantHost: "qa.documents", wantKey: "b3ZlcnJpZGU="},
+		{name: "variables only", vars: map[string]string{EnvCosmosEndpoint: "https://env.documents.azure.com/"}, wantEnv: DefaultEnvironment, wantHost: "env.documents"},
+		{name: "unknown environment", path: path, env: "staging", wantEnv: "staging", wantNoEnv: true},
+	}
+	for _, tt := range tests {
+		clearEnv(t)
+		for name, value := range tt.vars {
+			t.Setenv(name, value)
+		}
+		cfg, err := Load(tt.path, tt.env)
+		if tt.wantNoEnv {
+			if !errors.Is(err, ErrEnvironmentNotFound) || !strings.Contains(err.Error(), "available: prod, qa") {
+				t.Errorf("%s: error = %v, want the environment not found", tt.name, err)
+			}
+			if cfg.Rules["value-range"] != "warning" {
+				t.Errorf("%s: rules not loaded: %v", tt.name, cfg.Rules)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if cfg.Environment != tt.wantEnv || !strings.Contains(cfg.Cosmos.Endpoint, tt.wantHost) || cfg.Cosmos.Key != tt.wantKey {
+			t.Errorf("%s: got %s %s %s, want %s %s %s", tt.name, cfg.Environment, cfg.Cosmos.Endpoint, cfg.Cosmos.Key, tt.wantEnv, tt.wantHost, tt.wantKey)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	clearEnv(t)
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml"), ""); err == nil {
+		t.Error("a missing config file given by path is not an error")
+	}
+	if _, err := Load(writeFile(t, "environments: [\n"), ""); err == nil || !strings.Contains(err.Error(), "failed to parse") {
+		t.Errorf("invalid YAML: error = %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Config{Cosmos: Cosmos{
+		Endpoint:  "https://acc.documents.azure.com:443/",
+		Key:       "a2V5",
+		Database:  "db",
+		Container: "c",
+	}}
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   []string
+	}{
+		{"valid", func(c *Config) {}, nil},
+		{"empty", func(c *Config) { c.Cosmos = Cosmos{} }, []string{"endpoint is not set", "key is not set", "database is not set", "container is not set"}},
+		{"http endpoint", func(c *Config) { c.Cosmos.Endpoint = "http://acc.documents.azure.com" }, []string{"is not an https URL"}},
+		{"no host", func(c *Config) { c.Cosmos.Endpoint = "https://" }, []string{"is not an https URL"}},
+		{"key", func(c *Config) { c.Cosmos.Key = "not base64!" }, []string{"not valid base64"}},
+		{"retry", func(c *Config) { c.Cosmos.Retry.BudgetMs = -1 }, []string{"cannot be negative"}},
+	}
+	for _, tt := range tests {
+		c := valid
+		tt.change(&c)
+		errs := c.Validate()
+		if len(errs) != len(tt.want) {
+			t.Errorf("%s: Validate = %v, want %d errors", tt.name, errs, len(tt.want))
+			continue
+		}
+		for i, want := range tt.want {
+			if !strings.Contains(errs[i].Error(), want) {
+				t.Errorf("%s: error %d = %v, want %q", tt.name, i, errs[i], want)
+			}
+		}
+	}
+}
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		secret string
+		want   string
+	}{
+		{"", ""},
+		{"abc", "***"},
+		{"12345678", "********"},
+		{"cWEta2V5LXZhbHVlLTEyMzQ=", "********MzQ="},
+	}
+	for _, tt := range tests {
+		if got := Mask(tt.secret); got != tt.want {
+			t.Errorf("Mask(%q) = %q, want %q", tt.secret, got, tt.want)
+		}
+	}
+
+	c := Config{Cosmos: Cosmos{Key: "cWEta2V5LXZhbHVlLTEyMzQ="}}
+	if strings.Contains(c.String(), c.Cosmos.Key) {
+		t.Error("String prints the key")
+	}
+}
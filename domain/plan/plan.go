@@ -0,0 +1,167 @@
+package plan
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+)
+
+// Plan is the reviewable set of changes an ingest would make to the registry.
+type Plan struct {
+	File         string     `json:"file"`                   // ingested file
+	User         string     `json:"user"`                   // employee id running the ingest
+	Update       bool       `json:"update"`                 // whether the --update flag was set
+	UpdateFields []string   `json:"updateFields,omitempty"` // fields an update may change, all when empty (--update-fields)
+	CreatedUtc   string     `json:"createdUtc"`             // when the plan was made
+	RunID        string     `json:"runId,omitempty"`        // run applying the plan, see SetRunID
+	Creates      []Create   `json:"creates"`
+	Updates      []Update   `json:"updates"`
+	Skipped      []Skipped  `json:"skipped"`
+	Conflicts    []Conflict `json:"conflicts"`
+}
+
+// Create is a stream missing from the registry.
+type Create struct {
+	Line   int           `json:"line"`
+	Stream stream.Stream `json:"stream"`
+}
+
+// Update is a stream of the registry that differs from the file.
+// ETag is the version of the document read while planning and Incoming the stream
+// read from the file, used to merge the change again if the document was modified meanwhile,
+// with Patch the fields the file row sets and clears.
+type Update struct {
+	Line     int                  `json:"line"`
+	ETag     string               `json:"etag"`
+	Changes  []stream.FieldChange `json:"changes"`
+	Before   stream.Stream        `json:"before"`
+	After    stream.Stream        `json:"after"`
+	Incoming stream.Stream        `json:"incoming"`
+	Patch    stream.Patch         `json:"patch"`
+}
+
+// Skipped is a row that is not ingested because its SensorID was already seen in the file.
+type Skipped struct {
+	Line      int    `json:"line"`
+	SensorID  string `json:"sensorId"`
+	SiteCode  string `json:"siteCode"`
+	FirstLine int    `json:"firstLine"`
+}
+
+// Conflict is a row that cannot be ingested automatically.
+type Conflict struct {
+	Line     int           `json:"line"`
+	Reason   string        `json:"reason"`
+	Stream   stream.Stream `json:"stream"`
+	Existing []string      `json:"existing,omitempty"` // IDs of the registry streams involved
+}
+
+// New returns an empty plan for the given ingest.
+func New(file string, user string, update bool) *Plan {
+	return &Plan{
+		File:       file,
+		User:       user,
+		Update:     update,
+		CreatedUtc: time.Now().UTC().Format(time.RFC3339),
+		Creates:    make([]Create, 0),
+		Updates:    make([]Update, 0),
+		Skipped:    make([]Skipped, 0),
+		Conflicts:  make([]Conflict, 0),
+	}
+}
+
+func (p *Plan) AddCreate(line int, s stream.Stream) {
+	p.Creates = append(p.Creates, Create{Line: line, Stream: s})
+}
+
+func (p *Plan) AddUpdate(line int, before stream.Stream, after stream.Stream, incoming stream.Stream) {
+	p.Updates = append(p.Updates, Update{Line: line, ETag: before.ETag, Changes: stream.Diff(before, after), Before: before, After: after, Incoming: incoming, Patch: incoming.Patch})
+}
+
+func (p *Plan) AddSkipped(line int, s stream.Stream, firstLine int) {
+	p.Skipped = append(p.Skipped, Skipped{Line: line, SensorID: s.SensorID, SiteCode: s.SiteCode, FirstLine: firstLine})
+}
+
+func (p *Plan) AddConflict(line int, s stream.Stream, reason string, existing []stream.Stream) {
+	c := Conflict{Line: line, Reason: reason, Stream: s}
+	for _, e := range existing {
+		c.Existing = append(c.Existing, e.ID)
+	}
+	p.Conflicts = append(p.Conflicts, c)
+}
+
+// SetRunID records the run applying the plan on the plan and on every stream it writes.
+func (p *Plan) SetRunID(runID string) {
+	p.RunID = runID
+	for i := range p.Creates {
+		p.Creates[i].Stream.RunID = runID
+	}
+	for i := range p.Updates {
+		p.Updates[i].After.RunID = runID
+	}
+}
+
+// CreateStreams returns the streams to create.
+func (p *Plan) CreateStreams() []stream.Stream {
+	streams := make([]stream.Stream, len(p.Creates))
+	for i, c := range p.Creates {
+		streams[i] = c.Stream
+	}
+	return streams
+}
+
+// UpdateStreams returns the new state of the streams to update.
+func (p *Plan) UpdateStreams() []stream.Stream {
+	streams := make([]stream.Stream, len(p.Updates))
+	for i, u := range p.Updates {
+		streams[i] = u.After
+	}
+	return streams
+}
+
+// Lines returns the file line of each stream to create or update, by stream ID.
+func (p *Plan) Lines() map[string]int {
+	lines := make(map[string]int, len(p.Creates)+len(p.Updates))
+	for _, c := range p.Creates {
+		lines[c.Stream.ID] = c.Line
+	}
+	for _, u := range p.Updates {
+		lines[u.After.ID] = u.Line
+	}
+	return lines
+}
+
+// Summary returns a one-line description of the plan.
+func (p *Plan) Summary() string {
+	return fmt.Sprintf("%d to create, %d to update, %d skipped duplicates, %d conflicts",
+		len(p.Creates), len(p.Updates), len(p.Skipped), len(p.Conflicts))
+}
+
+// Save writes the plan as indented JSON.
+func (p *Plan) Save(fileName string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return errors.Join(errors.New("failed to marshal plan"), err)
+	}
+	if err = os.WriteFile(fileName, data, 0o644); err != nil {
+		return errors.Join(fmt.Errorf("failed to write plan %s", fileName), err)
+	}
+	return nil
+}
+
+// Load reads a plan written by Save.
+func Load(fileName string) (*Plan, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to read plan %s", fileName), err)
+	}
+	var p Plan
+	if err = json.Unmarshal(data, &p); err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to parse plan %s", fileName), err)
+	}
+	return &p, nil
+}
@@ -0,0 +1,84 @@
+package plan
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+)
+
+func newStream(id string, sensorID string) stream.Stream {
+	s := stream.NewStream()
+	s.ID, s.SiteCode, s.SensorID = id, "S1", sensorID
+	return s
+}
+
+func TestPlan(t *testing.T) {
+	p := New("in.csv", "me", true)
+	p.UpdateFields = []string{"streamName"}
+
+	before := newStream("2", "T2")
+	before.ETag = "e1"
+	after := before
+	after.StreamName = "new"
+	incoming := newStream("x", "T2")
+	incoming.StreamName = "new"
+	incoming.Patch = stream.Patch{"streamName": stream.FieldPresent, "uom": stream.FieldNull}
+
+	p.AddCreate(3, newStream("1", "T1"))
+	p.AddUpdate(4, before, after, incoming)
+	p.AddSkipped(5, newStream("y", "T1"), 3)
+	p.AddConflict(6, newStream("z", "T9"), "more than one stream found in the Registry", []stream.Stream{newStream("a", "T9"), newStream("b", "T9")})
+
+	if got := p.Summary(); got != "1 to create, 1 to update, 1 skipped duplicates, 1 conflicts" {
+		t.Errorf("Summary = %s", got)
+	}
+	if u := p.Updates[0]; u.ETag != "e1" || len(u.Changes) != 1 || u.Changes[0].Field != "streamName" {
+		t.Errorf("update = %+v, want the ETag read and the streamName change", u)
+	}
+	if got := p.Conflicts[0].Existing; !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("conflict existing = %v", got)
+	}
+	if got := p.Lines(); !reflect.DeepEqual(got, map[string]int{"1": 3, "2": 4}) {
+		t.Errorf("Lines = %v", got)
+	}
+
+	p.SetRunID("run1")
+	if p.CreateStreams()[0].RunID != "run1" || p.UpdateStreams()[0].RunID != "run1" || p.Updates[0].Before.RunID != "" {
+		t.Error("SetRunID must mark the streams written, and only them")
+	}
+
+	fileName := filepath.Join(t.TempDir(), "plan.json")
+	if err := p.Save(fileName); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Summary() != p.Summary() || loaded.RunID != "run1" || !reflect.DeepEqual(loaded.UpdateFields, p.UpdateFields) {
+		t.Errorf("loaded plan %+v differs", loaded)
+	}
+	// the patch is not part of the stream document, the plan keeps it
+	if got := loaded.Updates[0].Patch; !reflect.DeepEqual(got, incoming.Patch) {
+		t.Errorf("loaded patch = %v, want %v", got, incoming.Patch)
+	}
+	if loaded.Updates[0].Incoming.Patch != nil {
+		t.Error("the incoming stream carries its patch")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("a missing plan is loaded")
+	}
+	fileName := filepath.Join(t.TempDir(), "plan.json")
+	if err := New("in.csv", "me", false).Save(fileName); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Load(fileName)
+	if err != nil || len(p.Creates) != 0 || p.Update {
+		t.Errorf("empty plan = %+v, %v", p, err)
+	}
+}
@@ -0,0 +1,114 @@
+package run
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+
+	"github.com/google/uuid"
+)
+
+// Journal records what an ingest run wrote to the registry, so the run can be rolled back.
+type Journal struct {
+	RunID         string  `json:"runId"`
+	File          string  `json:"file"`    // ingested file
+	User          string  `json:"user"`    // employee id running the ingest
+	Backend       string  `json:"backend"` // registry written, as given to --backend (and --env for cosmos)
+	StartedUtc    string  `json:"startedUtc"`
+	Created       []Entry `json:"created"`
+	Updated       []Entry `json:"updated"`
+	RolledBackUtc string  `json:"rolledBackUtc,omitempty"`
+	RolledBackBy  string  `json:"rolledBackBy,omitempty"`
+}
+
+// Entry is a stream written by the run. The version an update replaced is kept in the stream history.
+type Entry struct {
+	Line     int    `json:"line"`
+	ID       string `json:"id"`
+	SensorID string `json:"sensorId"`
+	SiteCode string `json:"siteCode"`
+}
+
+// NewRunID returns a new run ID, starting with the time of the run so the IDs sort by date.
+func NewRunID() string {
+	return time.Now().UTC().Format("20060102T150405") + "-" + uuid.NewString()[:8]
+}
+
+// New returns an empty journal for the given run.
+func New(runID string, file string, user string, backend string) *Journal {
+	return &Journal{
+		RunID:      runID,
+		File:       file,
+		User:       user,
+		Backend:    backend,
+		StartedUtc: time.Now().UTC().Format(time.RFC3339),
+		Created:    make([]Entry, 0),
+		Updated:    make([]Entry, 0),
+	}
+}
+
+// AddCreated records a stream created by the run.
+func (j *Journal) AddCreated(line int, s stream.Stream) {
+	j.Created = append(j.Created, newEntry(line, s))
+}
+
+// AddUpdated records a stream updated by the run.
+func (j *Journal) AddUpdated(line int, s stream.Stream) {
+	j.Updated = append(j.Updated, newEntry(line, s))
+}
+
+// SetRolledBack records that the run was rolled back by user.
+func (j *Journal) SetRolledBack(user string) {
+	j.RolledBackUtc = time.Now().UTC().Format(time.RFC3339)
+	j.RolledBackBy = user
+}
+
+// Summary returns a one-line description of the journal.
+func (j *Journal) Summary() string {
+	return fmt.Sprintf("run %s of file %s by %s at %s: %d created, %d updated",
+		j.RunID, j.File, j.User, j.StartedUtc, len(j.Created), len(j.Updated))
+}
+
+// Path returns the file of the journal of runID in dir.
+func Path(dir string, runID string) string {
+	return filepath.Join(dir, runID+".json")
+}
+
+// Save writes the journal in dir, creating the directory if needed.
+func (j *Journal) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return errors.Join(fmt.Errorf("failed to create journal directory %s", dir), err)
+	}
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return errors.Join(errors.New("failed to marshal journal"), err)
+	}
+	fileName := Path(dir, j.RunID)
+	if err = os.WriteFile(fileName, data, 0o644); err != nil {
+		return errors.Join(fmt.Errorf("failed to write journal %s", fileName), err)
+	}
+	return nil
+}
+
+// Load reads the journal of runID saved in dir.
+func Load(dir string, runID string) (*Journal, error) {
+	fileName := Path(dir, runID)
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to read journal %s", fileName), err)
+	}
+	var j Journal
+	if err = json.Unmarshal(data, &j); err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to parse journal %s", fileName), err)
+	}
+	return &j, nil
+}
+
+func newEntry(line int, s stream.Stream) Entry {
+	return Entry{Line: line, ID: s.ID, SensorID: s.SensorID, SiteCode: s.SiteCode}
+}
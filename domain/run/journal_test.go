@@ -0,0 +1,62 @@
+package run
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+)
+
+func TestNewRunID(t *testing.T) {
+	a, b := NewRunID(), NewRunID()
+	if a == b || len(a) != len("20060102T150405-12345678") || a[8] != 'T' || a[15] != '-' {
+		t.Errorf("run IDs %s and %s", a, b)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	s := stream.NewStream()
+	s.SiteCode, s.SensorID = "S1", "T1"
+	u := stream.NewStream()
+	u.SiteCode, u.SensorID = "S2", "T2"
+
+	j := New("run1", "in.csv", "me", "file://store")
+	j.AddCreated(2, s)
+	j.AddUpdated(3, u)
+	if !strings.Contains(j.Summary(), "run run1 of file in.csv by me") || !strings.HasSuffix(j.Summary(), "1 created, 1 updated") {
+		t.Errorf("Summary = %s", j.Summary())
+	}
+	if got := j.Created[0]; got != (Entry{Line: 2, ID: s.ID, SensorID: "T1", SiteCode: "S1"}) {
+		t.Errorf("created entry = %+v", got)
+	}
+
+	dir := filepath.Join(t.TempDir(), "runs") // created by Save
+	if err := j.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := Path(dir, "run1"); got != filepath.Join(dir, "run1.json") {
+		t.Errorf("Path = %s", got)
+	}
+	loaded, err := Load(dir, "run1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, j) {
+		t.Errorf("loaded %+v, want %+v", loaded, j)
+	}
+
+	j.SetRolledBack("admin")
+	if err = j.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	loaded, _ = Load(dir, "run1")
+	if loaded.RolledBackBy != "admin" || loaded.RolledBackUtc == "" {
+		t.Errorf("rollback not recorded: %+v", loaded)
+	}
+
+	if _, err = Load(dir, "run2"); err == nil {
+		t.Error("the journal of an unknown run is loaded")
+	}
+}
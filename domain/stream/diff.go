@@ -0,0 +1,109 @@
+package stream
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Kind of FieldChange
+const (
+	Changed = "changed"
+	Added   = "added"
+	Removed = "removed"
+)
+
+// auditFields are not reported by Diff: they are managed by the registry, not by the ingested files.
+var auditFields = map[string]bool{
+	"ID":         true,
+	"Version":    true,
+	"CreatedBy":  true,
+	"UpdatedBy":  true,
+	"CreatedUtc": true,
+	"UpdatedUtc": true,
+	"RunID":      true,
+	"ETag":       true,
+}
+
+// FieldChange is a difference between two versions of a stream.
+// For a scalar field Field is the JSON name of the field, for a tag it is "tags.<name>".
+type FieldChange struct {
+	Field  string `json:"field"`
+	Kind   string `json:"kind"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+func (c FieldChange) String() string {
+	switch c.Kind {
+	case Added:
+		return fmt.Sprintf("%s added %q", c.Field, c.After)
+	case Removed:
+		return fmt.Sprintf("%s removed %q", c.Field, c.Before)
+	default:
+		return fmt.Sprintf("%s %q -> %q", c.Field, c.Before, c.After)
+	}
+}
+
+// Diff returns the changes needed to go from oldS to newS: every changed scalar field
+// in declaration order, then every removed and added tag sorted by name and value.
+// Audit fields are ignored.
+func Diff(oldS Stream, newS Stream) []FieldChange {
+	changes := make([]FieldChange, 0)
+
+	v1 := reflect.ValueOf(oldS)
+	v2 := reflect.ValueOf(newS)
+	t := v1.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		if auditFields[name] || name == "Tags" || name == "Patch" {
+			continue
+		}
+		before := formatValue(v1.Field(i))
+		after := formatValue(v2.Field(i))
+		if before != after {
+			changes = append(changes, FieldChange{Field: jsonName(t.Field(i)), Kind: Changed, Before: before, After: after})
+		}
+	}
+
+	var tagChanges []FieldChange
+	for _, tag := range oldS.Tags {
+		if !newS.Tags.Contains(tag) {
+			tagChanges = append(tagChanges, FieldChange{Field: TagField(tag.Name), Kind: Removed, Before: tag.Value})
+		}
+	}
+	for _, tag := range newS.Tags {
+		if !oldS.Tags.Contains(tag) {
+			tagChanges = append(tagChanges, FieldChange{Field: TagField(tag.Name), Kind: Added, After: tag.Value})
+		}
+	}
+	sort.SliceStable(tagChanges, func(i, j int) bool {
+		if tagChanges[i].Field != tagChanges[j].Field {
+			return tagChanges[i].Field < tagChanges[j].Field
+		}
+		if tagChanges[i].Kind != tagChanges[j].Kind {
+			// removed before added
+			return tagChanges[i].Kind > tagChanges[j].Kind
+		}
+		return tagChanges[i].Before+tagChanges[i].After < tagChanges[j].Before+tagChanges[j].After
+	})
+
+	return append(changes, tagChanges...)
+}
+
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// formatValue formats a field of a stream, a decimal as FormatDecimal does.
+func formatValue(v reflect.Value) string {
+	if v.Kind() == reflect.Float64 {
+		return FormatDecimal(v.Float())
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
@@ -0,0 +1,57 @@
+package stream
+
+import (
+	"testing"
+
+	"githb.com/Go-routine-4595/stream-ingest/model"
+)
+
+func TestDiff(t *testing.T) {
+	old := NewStream()
+	old.MaxValue = 100
+	old.Tags = NewTagSet(model.Tag{Name: "UDE", Value: "U1"}, model.Tag{Name: "System", Value: "Sys"})
+
+	tests := []struct {
+		name   string
+		change func(s *Stream)
+		want   []FieldChange
+	}{
+		{"same", func(s *Stream) {}, nil},
+		{"audit fields ignored", func(s *Stream) {
+			s.ID, s.Version, s.UpdatedBy, s.ETag, s.RunID = "other", 9, "me", "e", "run"
+		}, nil},
+		{"decimal", func(s *Stream) { s.MaxValue = 1000000.5 }, []FieldChange{
+			{Field: "maxValue", Kind: Changed, Before: "100", After: "1000000.5"},
+		}},
+		{"large decimal", func(s *Stream) { s.MaxValue = 1e6 }, []FieldChange{
+			{Field: "maxValue", Kind: Changed, Before: "100", After: "1000000"},
+		}},
+		{"fields in declaration order", func(s *Stream) { s.Step = false; s.UOM = "C" }, []FieldChange{
+			{Field: "uom", Kind: Changed, Before: "", After: "C"},
+			{Field: "step", Kind: Changed, Before: "true", After: "false"},
+		}},
+		{"tags", func(s *Stream) {
+			s.Tags.RemoveName("UDE")
+			s.Tags.Add(model.Tag{Name: "UDE", Value: "U2"}, model.Tag{Name: "Area", Value: "N"})
+		}, []FieldChange{
+			{Field: "tags.Area", Kind: Added, After: "N"},
+			{Field: "tags.UDE", Kind: Removed, Before: "U1"},
+			{Field: "tags.UDE", Kind: Added, After: "U2"},
+		}},
+	}
+	for _, tt := range tests {
+		newS := old
+		newS.Tags = old.Tags.Clone()
+		tt.change(&newS)
+		got := Diff(old, newS)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: Diff = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: change %d = %v, want %v", tt.name, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// IsField reports whether name is the JSON name of a Stream field an input file can set.
+// Audit fields, the registryType and the tags are managed by the registry.
+func IsField(name string) bool {
+	_, ok := fieldIndex(name)
+	return ok
+}
+
+// Fields returns the JSON names of the Stream fields an input file can set, in declaration order.
+func Fields() []string {
+	var names []string
+	t := reflect.TypeOf(Stream{})
+	for i := 0; i < t.NumField(); i++ {
+		if settable(t.Field(i)) {
+			names = append(names, jsonName(t.Field(i)))
+		}
+	}
+	return names
+}
+
+// SetField parses value and sets it in the field of s having the JSON name name.
+func (s *Stream) SetField(name string, value string) error {
+	i, ok := fieldIndex(name)
+	if !ok {
+		return fmt.Errorf("unknown stream field %s", name)
+	}
+	field := reflect.ValueOf(s).Elem().Field(i)
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		v, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", name, value, err)
+		}
+		field.SetInt(int64(v))
+	case reflect.Float64:
+		v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+			err = errors.New("not a finite number")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", name, value, err)
+		}
+		field.SetFloat(v)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", name, value, err)
+		}
+		field.SetBool(v)
+	default:
+		return fmt.Errorf("stream field %s cannot be set from text", name)
+	}
+	return nil
+}
+
+// IsDecimalField reports whether name is the JSON name of a decimal Stream field, such as minValue.
+func IsDecimalField(name string) bool {
+	i, ok := fieldIndex(name)
+	return ok && reflect.TypeOf(Stream{}).Field(i).Type.Kind() == reflect.Float64
+}
+
+// FormatDecimal formats a decimal field with the digits needed and without exponent, 100 as "100".
+func FormatDecimal(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// Decimals returns the number of digits of v after the decimal point.
+func Decimals(v float64) int {
+	text := FormatDecimal(v)
+	if i := strings.IndexByte(text, '.'); i >= 0 {
+		return len(text) - i - 1
+	}
+	return 0
+}
+
+func fieldIndex(name string) (int, bool) {
+	t := reflect.TypeOf(Stream{})
+	for i := 0; i < t.NumField(); i++ {
+		if settable(t.Field(i)) && jsonName(t.Field(i)) == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func settable(f reflect.StructField) bool {
+	return !auditFields[f.Name] && f.Name != "Tags" && f.Name != "RegistryType" && f.Name != "Patch"
+}
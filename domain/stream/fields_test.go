@@ -0,0 +1,86 @@
+package stream
+
+import "testing"
+
+func TestSetField(t *testing.T) {
+	tests := []struct {
+		field   string
+		value   string
+		want    string // the field formatted as Diff does
+		wantErr bool
+	}{
+		{"streamName", " Temp 1 ", " Temp 1 ", false},
+		{"precision", " 2 ", "2", false},
+		{"precision", "1.5", "", true},
+		{"minValue", "-12.75", "-12.75", false},
+		{"maxValue", "1e3", "1000", false},
+		{"maxValue", "abc", "", true},
+		{"maxValue", "NaN", "", true},
+		{"maxValue", "Inf", "", true},
+		{"step", "false", "false", false},
+		{"step", "no", "", true},
+		{"createdBy", "me", "", true},
+		{"tags", "x", "", true},
+		{"unknown", "x", "", true},
+	}
+	for _, tt := range tests {
+		s := NewStream()
+		err := s.SetField(tt.field, tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SetField(%s, %q) error = %v, want error %v", tt.field, tt.value, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		changes := Diff(NewStream(), s)
+		got := ""
+		for _, c := range changes {
+			if c.Field == tt.field {
+				got = c.After
+			}
+		}
+		if got != tt.want {
+			t.Errorf("SetField(%s, %q) set %q, want %q", tt.field, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestDecimals(t *testing.T) {
+	tests := []struct {
+		value    float64
+		text     string
+		decimals int
+	}{
+		{0, "0", 0},
+		{100, "100", 0},
+		{0.5, "0.5", 1},
+		{-12.75, "-12.75", 2},
+		{1e6, "1000000", 0},
+		{0.001, "0.001", 3},
+	}
+	for _, tt := range tests {
+		if got := FormatDecimal(tt.value); got != tt.text {
+			t.Errorf("FormatDecimal(%v) = %s, want %s", tt.value, got, tt.text)
+		}
+		if got := Decimals(tt.value); got != tt.decimals {
+			t.Errorf("Decimals(%v) = %d, want %d", tt.value, got, tt.decimals)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	for _, name := range []string{"siteCode", "sensorId", "minValue", "hiHi", "step"} {
+		if !IsField(name) {
+			t.Errorf("%s is not a field", name)
+		}
+	}
+	for _, name := range []string{"id", "tags", "registryType", "createdBy", "_etag", "runId", "Patch"} {
+		if IsField(name) {
+			t.Errorf("%s is a field an input file can set", name)
+		}
+	}
+	if !IsDecimalField("loLo") || IsDecimalField("precision") {
+		t.Error("IsDecimalField is wrong for loLo or precision")
+	}
+}
@@ -0,0 +1,40 @@
+package stream
+
+import "fmt"
+
+// RegistryTypeStreamHistory is the registryType of the documents keeping the previous versions of a stream.
+const RegistryTypeStreamHistory = "streamHistory"
+
+// History is a previous version of a stream. It is stored next to the stream, in the same
+// partition, with its own ID and StreamID pointing to the stream.
+type History struct {
+	Stream
+	StreamID string `json:"streamId"`
+}
+
+// NewHistory returns the history document keeping s before it is updated.
+func NewHistory(s Stream) History {
+	h := History{
+		Stream:   s,
+		StreamID: s.ID,
+	}
+	h.ID = HistoryID(s.ID, s.Version)
+	h.RegistryType = RegistryTypeStreamHistory
+	h.ETag = ""
+	h.Tags = s.Tags.Clone()
+	return h
+}
+
+// HistoryID returns the ID of the history document of the given version of a stream.
+func HistoryID(streamID string, version int) string {
+	return fmt.Sprintf("%s:v%d", streamID, version)
+}
+
+// Restore returns the stream as it was at this version, with the ID and registryType of the stream.
+func (h History) Restore() Stream {
+	s := h.Stream
+	s.ID = h.StreamID
+	s.RegistryType = RegistryTypeStream
+	s.Tags = h.Tags.Clone()
+	return s
+}
@@ -0,0 +1,115 @@
+package stream
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ClearValue is the value of a cell that blanks the field of its column.
+const ClearValue = "<clear>"
+
+// FieldState tells how an input row sets a field of a stream.
+type FieldState string
+
+// States of a field in a Patch
+const (
+	FieldAbsent  FieldState = ""        // the row leaves the field of the registry as is
+	FieldPresent FieldState = "present" // the row sets the field to its value
+	FieldNull    FieldState = "null"    // the row blanks the field, with ClearValue
+)
+
+// Patch holds the state of each field an input row sets, by JSON name; the other fields are absent.
+// The tags a row clears are named TagField(name). A nil Patch sets every field, as a stream written whole.
+type Patch map[string]FieldState
+
+// State returns how the patch sets the field having the JSON name name.
+func (p Patch) State(name string) FieldState {
+	if p == nil {
+		return FieldPresent
+	}
+	return p[name]
+}
+
+// TagField returns the name of the tags named name in a Patch, the one Diff reports them under.
+func TagField(name string) string {
+	return tagPrefix + name
+}
+
+// tagPrefix starts the name of the tags in a Patch and in the changes of Diff
+const tagPrefix = "tags."
+
+// tagsField names every tag in the fields of an update, TagField(name) the tags named name.
+const tagsField = "tags"
+
+// updatesTag tells if an update of fields, every field when nil, clears the tags named name.
+func updatesTag(fields []string, name string) bool {
+	return fields == nil || contains(fields, tagsField) || contains(fields, TagField(name))
+}
+
+// ClearedTags returns the names of the tags the patch clears, sorted.
+func (p Patch) ClearedTags() []string {
+	var names []string
+	for field, state := range p {
+		if name, ok := strings.CutPrefix(field, tagPrefix); ok && state == FieldNull {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// updatableFields are the fields of a stream of the registry an ingested file may change.
+var updatableFields = []string{"process", "streamName", "uom", "scaleFactor", "precision", "minValue", "maxValue", "loLo", "lo", "hi", "hiHi", "step"}
+
+// UpdatableFields returns the JSON names of the fields an update may change.
+func UpdatableFields() []string {
+	res := make([]string, len(updatableFields))
+	copy(res, updatableFields)
+	return res
+}
+
+// IsUpdatable reports whether name is the JSON name of a field an update may change.
+func IsUpdatable(name string) bool {
+	return contains(updatableFields, name)
+}
+
+// CheckUpdatable returns an error naming the fields an update may not change.
+// Besides the updatable fields, "tags" selects the tags cleared by the rows and "tags.<name>" the ones named name.
+func CheckUpdatable(fields []string) error {
+	var unknown []string
+	for _, name := range fields {
+		if !IsUpdatable(name) && name != tagsField && (!strings.HasPrefix(name, tagPrefix) || name == tagPrefix) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown update fields %s, expected some of %s, %s or %s<name>", strings.Join(unknown, ", "), strings.Join(updatableFields, ", "), tagsField, tagPrefix)
+	}
+	return nil
+}
+
+// ClearField blanks the field of s having the JSON name name, to its zero value.
+func (s *Stream) ClearField(name string) error {
+	i, ok := fieldIndex(name)
+	if !ok {
+		return fmt.Errorf("unknown stream field %s", name)
+	}
+	if !IsUpdatable(name) {
+		return fmt.Errorf("stream field %s cannot be cleared", name)
+	}
+	field := reflect.ValueOf(s).Elem().Field(i)
+	field.Set(reflect.Zero(field.Type()))
+	return nil
+}
+
+// contains tells if value is one of values.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package stream
+
+import (
+	"testing"
+
+	"githb.com/Go-routine-4595/stream-ingest/model"
+)
+
+func TestUpdateStream(t *testing.T) {
+	stored := NewStream()
+	stored.StreamName, stored.UOM, stored.MaxValue, stored.Hi, stored.Version = "Temp", "C", 100, 90, 3
+	stored.Tags = NewTagSet(model.Tag{Name: "UDE", Value: "U1"}, model.Tag{Name: "System", Value: "Sys"})
+
+	incoming := NewStream()
+	incoming.StreamName, incoming.MaxValue = "Temp 2", 0
+	incoming.Tags = NewTagSet(model.Tag{Name: "Area", Value: "N"})
+
+	tests := []struct {
+		name   string
+		patch  Patch
+		fields []string
+		want   []FieldChange
+	}{
+		{"nil patch sets every field", nil, nil, []FieldChange{
+			{Field: "streamName", Kind: Changed, Before: "Temp", After: "Temp 2"},
+			{Field: "uom", Kind: Changed, Before: "C", After: ""},
+			{Field: "maxValue", Kind: Changed, Before: "100", After: "0"},
+			{Field: "hi", Kind: Changed, Before: "90", After: "0"},
+			{Field: "tags.Area", Kind: Added, After: "N"},
+		}},
+		{"absent fields kept", Patch{"streamName": FieldPresent}, nil, []FieldChange{
+			{Field: "streamName", Kind: Changed, Before: "Temp", After: "Temp 2"},
+			{Field: "tags.Area", Kind: Added, After: "N"},
+		}},
+		{"null clears", Patch{"maxValue": FieldNull, "uom": FieldNull}, nil, []FieldChange{
+			{Field: "uom", Kind: Changed, Before: "C", After: ""},
+			{Field: "maxValue", Kind: Changed, Before: "100", After: "0"},
+			{Field: "tags.Area", Kind: Added, After: "N"},
+		}},
+		{"update fields", Patch{"streamName": FieldPresent, "maxValue": FieldPresent}, []string{"maxValue"}, []FieldChange{
+			{Field: "maxValue", Kind: Changed, Before: "100", After: "0"},
+			{Field: "tags.Area", Kind: Added, After: "N"},
+		}},
+		{"cleared tags", Patch{TagField("UDE"): FieldNull}, nil, []FieldChange{
+			{Field: "tags.Area", Kind: Added, After: "N"},
+			{Field: "tags.UDE", Kind: Removed, Before: "U1"},
+		}},
+		{"cleared tags not in update fields", Patch{TagField("UDE"): FieldNull, TagField("System"): FieldNull}, []string{"maxValue", TagField("System")}, []FieldChange{
+			{Field: "tags.Area", Kind: Added, After: "N"},
+			{Field: "tags.System", Kind: Removed, Before: "Sys"},
+		}},
+		{"every cleared tag in update fields", Patch{TagField("UDE"): FieldNull}, []string{"tags"}, []FieldChange{
+			{Field: "tags.Area", Kind: Added, After: "N"},
+			{Field: "tags.UDE", Kind: Removed, Before: "U1"},
+		}},
+		{"keys never updated", Patch{"siteCode": FieldPresent, "sensorId": FieldPresent}, nil, []FieldChange{
+			{Field: "tags.Area", Kind: Added, After: "N"},
+		}},
+	}
+	for _, tt := range tests {
+		s1 := stored
+		s1.Tags = stored.Tags.Clone()
+		s2 := incoming
+		s2.SiteCode, s2.SensorID = "other", "other"
+		s2.Patch = tt.patch
+		UpdateStream(&s1, &s2, "me", tt.fields)
+		got := Diff(stored, s1)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: changes = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: change %d = %v, want %v", tt.name, i, got[i], tt.want[i])
+			}
+		}
+		if s1.Version != stored.Version+1 || s1.UpdatedBy != "me" {
+			t.Errorf("%s: version %d by %s, want %d by me", tt.name, s1.Version, s1.UpdatedBy, stored.Version+1)
+		}
+	}
+}
+
+func TestClearField(t *testing.T) {
+	s := NewStream()
+	s.UOM, s.Hi = "C", 5
+	for _, name := range []string{"uom", "hi", "step"} {
+		if err := s.ClearField(name); err != nil {
+			t.Errorf("ClearField(%s): %v", name, err)
+		}
+	}
+	if s.UOM != "" || s.Hi != 0 || s.Step {
+		t.Errorf("cleared fields are %q %v %v", s.UOM, s.Hi, s.Step)
+	}
+	for _, name := range []string{"siteCode", "sensorId", "unknown"} {
+		if err := s.ClearField(name); err == nil {
+			t.Errorf("ClearField(%s) is accepted", name)
+		}
+	}
+}
+
+func TestCheckUpdatable(t *testing.T) {
+	if err := CheckUpdatable([]string{"uom", "hiHi", "tags", TagField("UDE")}); err != nil {
+		t.Error(err)
+	}
+	for _, name := range []string{"siteCode", "tags."} {
+		if err := CheckUpdatable([]string{"uom", name}); err == nil {
+			t.Errorf("%s is accepted as an update field", name)
+		}
+	}
+}
+
+func TestPatchClearedTags(t *testing.T) {
+	p := Patch{TagField("UDE"): FieldNull, TagField("Area"): FieldNull, TagField("System"): FieldPresent, "uom": FieldNull}
+	got := p.ClearedTags()
+	if len(got) != 2 || got[0] != "Area" || got[1] != "UDE" {
+		t.Errorf("ClearedTags = %v, want [Area UDE]", got)
+	}
+	if Patch(nil).State("uom") != FieldPresent || (Patch{}).State("uom") != FieldAbsent {
+		t.Error("the state of a nil patch is not present or of an empty patch not absent")
+	}
+}
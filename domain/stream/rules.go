@@ -0,0 +1,255 @@
+package stream
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity tells if a rule violation prevents ingesting a stream (error) or is only reported (warning).
+type Severity string
+
+// Severities of a rule, SeverityOff disables it
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityOff     Severity = "off"
+)
+
+// Rule IDs
+const (
+	RuleRequiredFields = "required-fields"
+	RuleValueRange     = "value-range"
+	RuleAlarmOrder     = "alarm-order"
+	RuleAlarmRange     = "alarm-range"
+	RulePrecision      = "precision"
+	RuleProcess        = "process"
+	RuleSiteShortCode  = "site-short-code"
+	RuleTagVocabulary  = "tag-vocabulary"
+)
+
+// Rule is a check of the definition of a stream.
+type Rule struct {
+	ID          string
+	Severity    Severity // default severity
+	Description string
+	check       func(v *Validator, s Stream) []string
+}
+
+// Violation is a stream breaking a rule.
+type Violation struct {
+	Rule     string
+	Severity Severity
+	Message  string
+}
+
+func (v Violation) Error() string {
+	return fmt.Sprintf("%s [%s]: %s", v.Severity, v.Rule, v.Message)
+}
+
+// rules are the rules checked by a Validator, in this order.
+// The alarm rules only check the alarm limits the stream sets, see alarmLimits.
+var rules = []Rule{
+	{RuleRequiredFields, SeverityError, "SiteCode, SensorID and StreamName are not empty", checkRequiredFields},
+	{RuleValueRange, SeverityError, "MinValue <= MaxValue", checkValueRange},
+	{RuleAlarmOrder, SeverityError, "LoLo <= Lo <= Hi <= HiHi", checkAlarmOrder},
+	{RuleAlarmRange, SeverityWarning, "the alarm limits are within MinValue and MaxValue", checkAlarmRange},
+	{RulePrecision, SeverityWarning, "MinValue, MaxValue and the alarm limits have at most Precision decimals", checkPrecision},
+	{RuleProcess, SeverityError, "Process is a known process", checkProcess},
+	{RuleSiteShortCode, SeverityWarning, "every stream of a SiteCode has the same SiteShortCode tag", checkSiteShortCode},
+	{RuleTagVocabulary, SeverityWarning, "tag names are known tags", checkTagVocabulary},
+}
+
+// Rules returns the rules with their default severity.
+func Rules() []Rule {
+	res := make([]Rule, len(rules))
+	copy(res, rules)
+	return res
+}
+
+// RuleIDs returns the ID of every rule.
+func RuleIDs() []string {
+	ids := make([]string, len(rules))
+	for i, r := range rules {
+		ids[i] = r.ID
+	}
+	return ids
+}
+
+// Validator checks streams against the rules. It remembers the streams already checked for
+// the rules comparing streams together, so the streams of a file go through the same Validator.
+type Validator struct {
+	severity   map[string]Severity
+	knownTags  map[string]bool
+	shortCodes map[string]siteShortCode // first SiteShortCode seen per SiteCode
+}
+
+type siteShortCode struct {
+	value    string
+	sensorID string
+}
+
+// NewValidator returns a Validator applying every rule with its default severity.
+// knownTags are tag names accepted besides the ones of IsTag, such as the tags of the input mapping.
+func NewValidator(knownTags ...string) *Validator {
+	v := &Validator{
+		severity:   make(map[string]Severity, len(rules)),
+		knownTags:  make(map[string]bool, len(knownTags)),
+		shortCodes: make(map[string]siteShortCode),
+	}
+	for _, r := range rules {
+		v.severity[r.ID] = r.Severity
+	}
+	for _, tag := range knownTags {
+		v.knownTags[tag] = true
+	}
+	return v
+}
+
+// SetSeverity changes the severity of the rule id, SeverityOff disables it.
+func (v *Validator) SetSeverity(id string, severity Severity) error {
+	if _, ok := v.severity[id]; !ok {
+		return fmt.Errorf("unknown rule %s, expected one of %s", id, strings.Join(RuleIDs(), ", "))
+	}
+	switch severity {
+	case SeverityError, SeverityWarning, SeverityOff:
+		v.severity[id] = severity
+		return nil
+	default:
+		return fmt.Errorf("unknown severity %s for rule %s, expected %s, %s or %s", severity, id, SeverityError, SeverityWarning, SeverityOff)
+	}
+}
+
+// Validate returns the rules the stream breaks, none when it is valid.
+func (v *Validator) Validate(s Stream) []Violation {
+	var violations []Violation
+	for _, r := range rules {
+		severity := v.severity[r.ID]
+		if severity == SeverityOff {
+			continue
+		}
+		for _, msg := range r.check(v, s) {
+			violations = append(violations, Violation{Rule: r.ID, Severity: severity, Message: msg})
+		}
+	}
+	return violations
+}
+
+// HasError tells if one of the violations is an error.
+func HasError(violations []Violation) bool {
+	for _, v := range violations {
+		if v.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func checkRequiredFields(_ *Validator, s Stream) []string {
+	var msgs []string
+	for _, f := range []struct{ name, value string }{
+		{"SiteCode", s.SiteCode},
+		{"SensorID", s.SensorID},
+		{"StreamName", s.StreamName},
+	} {
+		if strings.TrimSpace(f.value) == "" {
+			msgs = append(msgs, f.name+" is empty")
+		}
+	}
+	return msgs
+}
+
+func checkValueRange(_ *Validator, s Stream) []string {
+	if s.MinValue > s.MaxValue {
+		return []string{fmt.Sprintf("MinValue %s is greater than MaxValue %s", FormatDecimal(s.MinValue), FormatDecimal(s.MaxValue))}
+	}
+	return nil
+}
+
+// alarmLimit is an alarm limit of a stream
+type alarmLimit struct {
+	name  string
+	value float64
+}
+
+// alarmLimits returns the alarm limits set, from the lowest to the highest. A limit is set when
+// the Patch of the stream has it present, so a limit of 0 is checked like any other; every limit
+// of a stream without Patch, as stored in the registry, is set.
+func alarmLimits(s Stream) []alarmLimit {
+	var limits []alarmLimit
+	for _, l := range []struct {
+		field string
+		alarmLimit
+	}{{"loLo", alarmLimit{"LoLo", s.LoLo}}, {"lo", alarmLimit{"Lo", s.Lo}}, {"hi", alarmLimit{"Hi", s.Hi}}, {"hiHi", alarmLimit{"HiHi", s.HiHi}}} {
+		if s.Patch.State(l.field) == FieldPresent {
+			limits = append(limits, l.alarmLimit)
+		}
+	}
+	return limits
+}
+
+func checkAlarmOrder(_ *Validator, s Stream) []string {
+	var msgs []string
+	limits := alarmLimits(s)
+	for i := 1; i < len(limits); i++ {
+		if limits[i-1].value > limits[i].value {
+			msgs = append(msgs, fmt.Sprintf("%s %s is greater than %s %s", limits[i-1].name, FormatDecimal(limits[i-1].value), limits[i].name, FormatDecimal(limits[i].value)))
+		}
+	}
+	return msgs
+}
+
+func checkAlarmRange(_ *Validator, s Stream) []string {
+	var msgs []string
+	for _, l := range alarmLimits(s) {
+		if l.value < s.MinValue || l.value > s.MaxValue {
+			msgs = append(msgs, fmt.Sprintf("%s %s is outside MinValue %s and MaxValue %s", l.name, FormatDecimal(l.value), FormatDecimal(s.MinValue), FormatDecimal(s.MaxValue)))
+		}
+	}
+	return msgs
+}
+
+func checkPrecision(_ *Validator, s Stream) []string {
+	var msgs []string
+	for _, l := range []alarmLimit{{"MinValue", s.MinValue}, {"MaxValue", s.MaxValue}, {"LoLo", s.LoLo}, {"Lo", s.Lo}, {"Hi", s.Hi}, {"HiHi", s.HiHi}} {
+		if Decimals(l.value) > s.Precision {
+			msgs = append(msgs, fmt.Sprintf("%s %s has more decimals than Precision %d", l.name, FormatDecimal(l.value), s.Precision))
+		}
+	}
+	return msgs
+}
+
+func checkProcess(_ *Validator, s Stream) []string {
+	if !IsProcess(s.Process) {
+		return []string{fmt.Sprintf("unknown Process %q", s.Process)}
+	}
+	return nil
+}
+
+func checkSiteShortCode(v *Validator, s Stream) []string {
+	value := s.Tags.Value(SiteShortCode)
+	if value == "" {
+		return []string{fmt.Sprintf("no %s tag for SiteCode %s", SiteShortCode, s.SiteCode)}
+	}
+	first, ok := v.shortCodes[s.SiteCode]
+	if !ok {
+		v.shortCodes[s.SiteCode] = siteShortCode{value: value, sensorID: s.SensorID}
+		return nil
+	}
+	if first.value != value {
+		return []string{fmt.Sprintf("%s %s differs from %s of SiteCode %s in stream %s", SiteShortCode, value, first.value, s.SiteCode, first.sensorID)}
+	}
+	return nil
+}
+
+func checkTagVocabulary(v *Validator, s Stream) []string {
+	var unknown []string
+	for _, name := range s.Tags.Names() {
+		if !IsTag(name) && !v.knownTags[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	return []string{"unknown tags " + strings.Join(unknown, ", ")}
+}
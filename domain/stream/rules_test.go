@@ -0,0 +1,103 @@
+package stream
+
+import (
+	"strings"
+	"testing"
+
+	"githb.com/Go-routine-4595/stream-ingest/model"
+)
+
+// validStream returns a stream read from a file breaking no rule, without alarm limits.
+func validStream(sensorID string) Stream {
+	s := NewStream()
+	s.SiteCode, s.SensorID, s.StreamName, s.Process = "S1", sensorID, "Temp", CNCCrushConvey
+	s.MinValue, s.MaxValue = 0, 100
+	s.Patch = Patch{"siteCode": FieldPresent, "sensorId": FieldPresent, "streamName": FieldPresent, "process": FieldPresent, "minValue": FieldPresent, "maxValue": FieldPresent}
+	s.Tags = NewTagSet(model.Tag{Name: SiteShortCode, Value: "S1S"})
+	return s
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(s *Stream)
+		want   []string // rule of each violation
+	}{
+		{"valid", func(s *Stream) {}, nil},
+		{"required fields", func(s *Stream) { s.SiteCode, s.StreamName = "", " " }, []string{RuleRequiredFields, RuleRequiredFields}},
+		{"value range", func(s *Stream) { s.MinValue = 200 }, []string{RuleValueRange}},
+		{"alarm order", func(s *Stream) { s.Lo, s.Hi, s.Patch = 50, 40, Patch{"lo": FieldPresent, "hi": FieldPresent} }, []string{RuleAlarmOrder}},
+		{"unset alarm limits", func(s *Stream) { s.Lo, s.Patch = 10, Patch{"lo": FieldPresent, "hi": FieldNull} }, nil},
+		{"alarm limit set to 0", func(s *Stream) { s.Lo, s.Patch = 10, Patch{"lo": FieldPresent, "hi": FieldPresent} }, []string{RuleAlarmOrder}},
+		{"alarm limits without patch", func(s *Stream) { s.LoLo, s.Lo, s.Hi, s.HiHi, s.Patch = 5, 10, 90, 95, nil }, nil},
+		{"alarm limit 0 without patch", func(s *Stream) { s.Lo, s.Patch = 10, nil }, []string{RuleAlarmOrder}},
+		{"alarm range", func(s *Stream) { s.HiHi, s.Patch = 150, Patch{"hiHi": FieldPresent} }, []string{RuleAlarmRange}},
+		{"alarm range of a limit set to 0", func(s *Stream) { s.MinValue, s.Lo, s.Patch = 10, 0, Patch{"lo": FieldPresent} }, []string{RuleAlarmRange}},
+		{"precision", func(s *Stream) { s.MaxValue = 99.5 }, []string{RulePrecision}},
+		{"precision given", func(s *Stream) { s.MaxValue, s.Precision = 99.5, 1 }, nil},
+		{"process", func(s *Stream) { s.Process = "XYZ" }, []string{RuleProcess}},
+		{"no site short code", func(s *Stream) { s.Tags = TagSet{} }, []string{RuleSiteShortCode}},
+		{"tag vocabulary", func(s *Stream) { s.Tags.Add(model.Tag{Name: "Colour", Value: "red"}) }, []string{RuleTagVocabulary}},
+		{"known tag", func(s *Stream) { s.Tags.Add(model.Tag{Name: "Area", Value: "N"}) }, nil},
+	}
+	for _, tt := range tests {
+		s := validStream("T1")
+		s.Tags = s.Tags.Clone()
+		tt.change(&s)
+		got := NewValidator("Area").Validate(s)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: violations = %v, want rules %v", tt.name, got, tt.want)
+			continue
+		}
+		for i, rule := range tt.want {
+			if got[i].Rule != rule {
+				t.Errorf("%s: violation %d = %v, want rule %s", tt.name, i, got[i], rule)
+			}
+		}
+	}
+}
+
+func TestSiteShortCodeAcrossStreams(t *testing.T) {
+	v := NewValidator()
+	first := validStream("T1")
+	other := validStream("T2")
+	other.Tags = NewTagSet(model.Tag{Name: SiteShortCode, Value: "XX"})
+	if got := v.Validate(first); len(got) != 0 {
+		t.Fatalf("first stream: %v", got)
+	}
+	got := v.Validate(other)
+	if len(got) != 1 || got[0].Rule != RuleSiteShortCode || !strings.Contains(got[0].Message, "T1") {
+		t.Errorf("second stream: %v, want a site-short-code violation naming T1", got)
+	}
+}
+
+func TestSetSeverity(t *testing.T) {
+	s := validStream("T1")
+	s.MinValue = 200 // value-range error
+	s.Process = "XYZ"
+
+	v := NewValidator()
+	if !HasError(v.Validate(s)) {
+		t.Fatal("no error by default")
+	}
+	if err := v.SetSeverity(RuleValueRange, SeverityWarning); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.SetSeverity(RuleProcess, SeverityOff); err != nil {
+		t.Fatal(err)
+	}
+	got := v.Validate(s)
+	if HasError(got) || len(got) != 1 || got[0].Severity != SeverityWarning {
+		t.Errorf("violations = %v, want one warning", got)
+	}
+	if got[0].Error() != "warning [value-range]: MinValue 200 is greater than MaxValue 100" {
+		t.Errorf("message = %s", got[0].Error())
+	}
+
+	if err := v.SetSeverity("unknown", SeverityOff); err == nil {
+		t.Error("an unknown rule is accepted")
+	}
+	if err := v.SetSeverity(RuleProcess, "fatal"); err == nil {
+		t.Error("an unknown severity is accepted")
+	}
+}
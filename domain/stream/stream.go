@@ -6,7 +6,6 @@ import (
 	"github.com/google/uuid"
 	"reflect"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -93,39 +92,47 @@ func IsTag(s string) bool {
 		s == SiteShortCode
 }
 
+// RegistryTypeStream is the registryType of the stream documents.
+const RegistryTypeStream = "stream"
+
 // Stream represents the structure of the stream item.
 type Stream struct {
-	ID           string        `json:"id"`
-	RegistryType string        `json:"registryType"`
-	Index        int           `json:"index"`
-	SiteCode     string        `json:"siteCode"`
-	Process      string        `json:"process"`
-	StreamName   string        `json:"streamName"`
-	SensorID     string        `json:"sensorId"`
-	UOM          string        `json:"uom"`
-	ScaleFactor  int           `json:"scaleFactor"`
-	Precision    int           `json:"precision"`
-	MinValue     int           `json:"minValue"`
-	MaxValue     int           `json:"maxValue"`
-	LoLo         int           `json:"loLo"`
-	Lo           int           `json:"lo"`
-	Hi           int           `json:"hi"`
-	HiHi         int           `json:"hiHi"`
-	Step         bool          `json:"step"`
-	Tags         []interface{} `json:"tags"` // To be filled later
-	Status       string        `json:"status"`
-	Version      int           `json:"version"`
-	CreatedBy    string        `json:"createdBy"`
-	UpdatedBy    string        `json:"updatedBy"`
-	CreatedUtc   string        `json:"createdUtc"`
-	UpdatedUtc   string        `json:"updatedUtc"`
+	ID           string  `json:"id"`
+	RegistryType string  `json:"registryType"`
+	Index        int     `json:"index"`
+	SiteCode     string  `json:"siteCode"`
+	Process      string  `json:"process"`
+	StreamName   string  `json:"streamName"`
+	SensorID     string  `json:"sensorId"`
+	UOM          string  `json:"uom"`
+	ScaleFactor  int     `json:"scaleFactor"`
+	Precision    int     `json:"precision"`
+	MinValue     float64 `json:"minValue"`
+	MaxValue     float64 `json:"maxValue"`
+	LoLo         float64 `json:"loLo"`
+	Lo           float64 `json:"lo"`
+	Hi           float64 `json:"hi"`
+	HiHi         float64 `json:"hiHi"`
+	Step         bool    `json:"step"`
+	Tags         TagSet  `json:"tags"`
+	Status       string  `json:"status"`
+	Version      int     `json:"version"`
+	CreatedBy    string  `json:"createdBy"`
+	UpdatedBy    string  `json:"updatedBy"`
+	CreatedUtc   string  `json:"createdUtc"`
+	UpdatedUtc   string  `json:"updatedUtc"`
+	RunID        string  `json:"runId,omitempty"` // ingest run that wrote this version
+	ETag         string  `json:"_etag,omitempty"` // set by the repository on every write
+
+	// Patch tells the fields the input row sets, clears or leaves as is, nil when it sets every field.
+	Patch Patch `json:"-"`
 }
 
 // NewStream creates and returns a new Stream with default values.
 func NewStream() Stream {
 	return Stream{
 		ID:           uuid.NewString(),
-		RegistryType: "stream",
+		RegistryType: RegistryTypeStream,
 		Index:        1,
 		SiteCode:     "",
 		Process:      "",
@@ -141,7 +148,7 @@ func NewStream() Stream {
 		Hi:           0,
 		HiHi:         0,
 		Step:         true,
-		Tags:         []interface{}{}, // To be filled later
+		Tags:         TagSet{},
 		Status:       "active",
 		Version:      1,
 	}
@@ -167,76 +174,31 @@ func (s Stream) SetUpdateBy(user string) Stream {
 }
 
 // UpdateTags updates the Tags field by adding new tags that are not already present
+// and bumps the Version
 func UpdateTags(stream1 *Stream, stream2 *Stream, user string) {
-	existingTagsSet := make(map[model.Tag]bool)
-
-	// Add existing tags to the set for quick lookup
-	for _, tag := range stream1.Tags {
-		var t model.Tag
-		t.Value = tag.(map[string]interface{})["value"].(string)
-		t.Name = tag.(map[string]interface{})["name"].(string)
-		existingTagsSet[t] = true
-	}
-
-	// Add only the new tags that are not already in the set
-	for _, tag := range stream2.Tags {
-		if _, exists := existingTagsSet[tag.(model.Tag)]; !exists {
-			var interfaceValue interface{} = tag
-			stream1.Tags = append(stream1.Tags, interfaceValue)
-		}
-	}
+	stream1.Tags.Add(stream2.Tags...)
+	stream1.Version++
 	*stream1 = stream1.SetUpdateBy(user)
-
 }
 
-// UpdateStreamOld updates the fields of stream1 with the fields of stream2.
-// Only non-zero or non-default values from stream2 will overwrite those in stream1.
-func UpdateStreamOld(stream1 *Stream, stream2 *Stream, user string) {
-	v1 := reflect.ValueOf(stream1).Elem()
-	v2 := reflect.ValueOf(stream2).Elem()
-
-	for i := 0; i < v1.NumField(); i++ {
-		field1 := v1.Field(i)
-		field2 := v2.Field(i)
-
-		if field2.IsValid() && field2.Interface() != reflect.Zero(field2.Type()).Interface() {
-			// Skip updating the CreatedUtc and CreatedBy field if required to preserve its originality
-			if name := v1.Type().Field(i).Name; name == "CreatedUtc" {
-				continue
-			}
-			if name := v1.Type().Field(i).Name; name == "CreatedBy" {
-				continue
-			}
-			if name := v1.Type().Field(i).Name; name == "UpdatedUtc" {
-				field1.Set(reflect.ValueOf(formatUtcTimestamp(time.Now())))
-				continue
-			}
-			if name := v1.Type().Field(i).Name; name == "UpdateBy" {
-				field1.Set(reflect.ValueOf(user))
-				continue
-			}
-			if name := v1.Type().Field(i).Name; name == "ID" {
-				continue
-			}
-			field1.Set(field2)
+// UpdateStream updates the fields of stream1 that stream2 sets or clears in its Patch, only the ones
+// in fields when given, removes the tags stream2 clears, only with "tags" or their TagField in fields
+// when given, and UpdateTag set the modify by/and date
+func UpdateStream(s1 *Stream, s2 *Stream, user string, fields []string) {
+	v1 := reflect.ValueOf(s1).Elem()
+	v2 := reflect.ValueOf(s2).Elem()
+	for _, name := range updatableFields {
+		if s2.Patch.State(name) == FieldAbsent || (fields != nil && !contains(fields, name)) {
+			continue
+		}
+		i, _ := fieldIndex(name)
+		v1.Field(i).Set(v2.Field(i))
+	}
+	for _, name := range s2.Patch.ClearedTags() {
+		if updatesTag(fields, name) {
+			s1.Tags.RemoveName(name)
 		}
 	}
-}
-
-// UpdateStream updates the fields of stream1 with the fields of stream2.
-// and UpdateTag set the modify by/and date
-func UpdateStream(s1 *Stream, s2 *Stream, user string) {
-	s1.Process = s2.Process
-	s1.StreamName = s2.StreamName
-	s1.UOM = s2.UOM
-	s1.ScaleFactor = s2.ScaleFactor
-	s1.Precision = s2.Precision
-	s1.MinValue = s2.MinValue
-	s1.MaxValue = s2.MaxValue
-	s1.LoLo = s2.LoLo
-	s1.Lo = s2.Lo
-	s1.Hi = s2.Hi
-	s1.HiHi = s2.HiHi
 	UpdateTags(s1, s2, user)
 }
 
@@ -259,76 +221,39 @@ func CompareStreams(s1 Stream, s2 Stream) bool {
 		s1.Lo == s2.Lo &&
 		s1.Hi == s2.Hi &&
 		s1.HiHi == s2.HiHi &&
-		compareTags(s1.Tags, s2.Tags)
-}
-
-// compareTags is a helper function to compare two slices of interface{} representing tags.
-func compareTags(tags1 []interface{}, tags2 []interface{}) bool {
-	if len(tags1) != len(tags2) {
-		return false
-	}
-
-	// Convert tags slices to maps for easy comparison
-	tagMap1 := make(map[model.Tag]bool)
-	tagMap2 := make(map[model.Tag]bool)
-
-	for _, tag := range tags1 {
-		if t, ok := tag.(model.Tag); ok {
-			tagMap1[t] = true
-		}
-	}
-
-	for _, tag := range tags2 {
-		if t, ok := tag.(model.Tag); ok {
-			tagMap2[t] = true
-		}
-	}
-
-	// Compare tag maps
-	if len(tagMap1) != len(tagMap2) {
-		return false
-	}
-
-	for tag := range tagMap1 {
-		if !tagMap2[tag] {
-			return false
-		}
-	}
-
-	return true
+		s1.Tags.Equal(s2.Tags)
 }
 
 // ConvertStreamToItem converts a Stream structure to an Item structure.
+// Column fields are filled from the tags the CSV reader creates for them,
+// EquipmentUnit is not stored in the registry and stays empty.
 func (s Stream) ConvertStreamToItem() model.Item {
-	// Convert Tags from []interface{} to []Tag
-	var tags []model.Tag
-	for _, t := range s.Tags {
-		//if tag, ok := t.(map[string]interface{}); ok {
-		//	for key, value := range tag {
-		//		tags = append(tags, model.Tag{Name: key, Value: toString(value)})
-		//	}
-		//}
-		tag := t.(model.Tag)
-		tags = append(tags, model.Tag{Name: tag.Name, Value: tag.Value})
-	}
+	tags := make([]model.Tag, len(s.Tags))
+	copy(tags, s.Tags)
 
 	// Return the converted Item
 	return model.Item{
-		SiteCode:      s.SiteCode,
-		SensorID:      s.SensorID,
-		Name:          s.StreamName,
-		Process:       s.Process,
-		MinValue:      strconv.Itoa(s.MinValue),
-		MaxValue:      strconv.Itoa(s.MaxValue),
-		UOM:           s.UOM,
-		SiteShortCode: getValueForKey(s.Tags, SiteShortCode),
-		//System:               mapSystemFromRegistryType(s.RegistryType),
-		EquipmentUnit:        getValueForKey(s.Tags, EquipmentType), //TODO check this one
-		Subunit:              getValueForKey(s.Tags, "Subunit"),
-		EquipmentComponent:   getValueForKey(s.Tags, EquipmentComponent),
-		EquipmentMeasurement: getValueForKey(s.Tags, EquipmentMeasurement),
-		UDE:                  getValueForKey(s.Tags, UDE),
-		SAPEquipmentID:       getValueForKey(s.Tags, SAPEquipmentID),
+		SiteCode:             s.SiteCode,
+		SensorID:             s.SensorID,
+		Name:                 s.StreamName,
+		Process:              s.Process,
+		MinValue:             FormatDecimal(s.MinValue),
+		MaxValue:             FormatDecimal(s.MaxValue),
+		UOM:                  s.UOM,
+		ScaleFactor:          strconv.Itoa(s.ScaleFactor),
+		Precision:            strconv.Itoa(s.Precision),
+		LoLo:                 FormatDecimal(s.LoLo),
+		Lo:                   FormatDecimal(s.Lo),
+		Hi:                   FormatDecimal(s.Hi),
+		HiHi:                 FormatDecimal(s.HiHi),
+		Step:                 strconv.FormatBool(s.Step),
+		SiteShortCode:        s.Tags.Value(SiteShortCode),
+		System:               s.Tags.Value("System"),
+		Subunit:              s.Tags.Value("Subunit"),
+		EquipmentComponent:   s.Tags.Value(EquipmentComponent),
+		EquipmentMeasurement: s.Tags.Value(EquipmentMeasurement),
+		UDE:                  s.Tags.Value(UDE),
+		SAPEquipmentID:       s.Tags.Value("SAP Equipment ID"),
 		Tags:                 tags,
 	}
 }
@@ -340,25 +265,3 @@ func toString(value interface{}) string {
 	}
 	return fmt.Sprintf("%v", value)
 }
-
-func getValueForKey(tags []interface{}, key string) string {
-	var res []string
-	for _, t := range tags {
-		if tag, ok := t.(model.Tag); ok {
-			if tag.Name == key {
-				res = append(res, tag.Value)
-			}
-		}
-	}
-	return strings.Join(res, ",")
-}
-
-// getStringFormTags converts a list of tags represented as []interface{} into a single comma-separated string.
-func getStringFormTags(data []interface{}) string {
-	var res []string
-
-	for _, tag := range data {
-		res = append(res, tag.(model.Tag).Value)
-	}
-	return strings.Join(res, ",")
-}
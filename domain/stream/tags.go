@@ -0,0 +1,152 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"githb.com/Go-routine-4595/stream-ingest/model"
+)
+
+// TagSet is the set of tags of a stream.
+// A name can hold several values, a name/value pair is stored only once
+// and the tags are always kept sorted by name then value.
+type TagSet []model.Tag
+
+// NewTagSet returns a TagSet holding the given tags.
+func NewTagSet(tags ...model.Tag) TagSet {
+	t := TagSet{}
+	t.Add(tags...)
+	return t
+}
+
+// Add inserts the tags not already in the set and reports whether at least one was added.
+func (t *TagSet) Add(tags ...model.Tag) bool {
+	added := false
+	for _, tag := range tags {
+		i, found := t.search(tag)
+		if found {
+			continue
+		}
+		*t = append(*t, model.Tag{})
+		copy((*t)[i+1:], (*t)[i:])
+		(*t)[i] = tag
+		added = true
+	}
+	return added
+}
+
+// Remove deletes the tag from the set and reports whether it was present.
+func (t *TagSet) Remove(tag model.Tag) bool {
+	i, found := t.search(tag)
+	if !found {
+		return false
+	}
+	*t = append((*t)[:i], (*t)[i+1:]...)
+	return true
+}
+
+// RemoveName deletes the tags named name from the set and reports whether there was one.
+func (t *TagSet) RemoveName(name string) bool {
+	res := (*t)[:0]
+	for _, tag := range *t {
+		if tag.Name != name {
+			res = append(res, tag)
+		}
+	}
+	removed := len(res) < len(*t)
+	*t = res
+	return removed
+}
+
+// Contains reports whether the name/value pair is in the set.
+func (t TagSet) Contains(tag model.Tag) bool {
+	_, found := t.search(tag)
+	return found
+}
+
+// Values returns the values of the tags named name, sorted.
+func (t TagSet) Values(name string) []string {
+	var res []string
+	for _, tag := range t {
+		if tag.Name == name {
+			res = append(res, tag.Value)
+		}
+	}
+	return res
+}
+
+// Value returns the values of the tags named name joined by a comma,
+// the format used by the multi-value columns of the CSV files.
+func (t TagSet) Value(name string) string {
+	return strings.Join(t.Values(name), ",")
+}
+
+// Names returns the distinct tag names, sorted.
+func (t TagSet) Names() []string {
+	var res []string
+	for i, tag := range t {
+		if i == 0 || t[i-1].Name != tag.Name {
+			res = append(res, tag.Name)
+		}
+	}
+	return res
+}
+
+// Equal reports whether both sets hold the same tags.
+func (t TagSet) Equal(o TagSet) bool {
+	if len(t) != len(o) {
+		return false
+	}
+	for i := range t {
+		if t[i] != o[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns a copy of the set that can be modified independently.
+func (t TagSet) Clone() TagSet {
+	return append(TagSet{}, t...)
+}
+
+// MarshalJSON writes the set as the array of {"name", "value"} objects stored in the registry.
+func (t TagSet) MarshalJSON() ([]byte, error) {
+	if t == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]model.Tag(t))
+}
+
+// UnmarshalJSON reads an array of {"name", "value"} objects. Values that are not strings
+// are converted, null is read as an empty set and duplicates are dropped.
+func (t *TagSet) UnmarshalJSON(data []byte) error {
+	var raw []map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return errors.Join(errors.New("failed to unmarshal tags"), err)
+	}
+	res := TagSet{}
+	for _, r := range raw {
+		name, ok := r["name"]
+		if !ok {
+			return fmt.Errorf("failed to unmarshal tags: tag without name %v", r)
+		}
+		res.Add(model.Tag{Name: toString(name), Value: toString(r["value"])})
+	}
+	*t = res
+	return nil
+}
+
+// search returns the position of tag in the set, or where it should be inserted.
+func (t TagSet) search(tag model.Tag) (int, bool) {
+	i := sort.Search(len(t), func(i int) bool {
+		if t[i].Name != tag.Name {
+			return t[i].Name > tag.Name
+		}
+		return t[i].Value >= tag.Value
+	})
+	return i, i < len(t) && t[i] == tag
+}
@@ -0,0 +1,72 @@
+package stream
+
+import (
+	"encoding/json"
+	"testing"
+
+	"githb.com/Go-routine-4595/stream-ingest/model"
+)
+
+func TestTagSetOrder(t *testing.T) {
+	set := NewTagSet(
+		model.Tag{Name: "UDE", Value: "U2"},
+		model.Tag{Name: "System", Value: "Sys"},
+		model.Tag{Name: "UDE", Value: "U1"},
+		model.Tag{Name: "UDE", Value: "U2"},
+	)
+	want := []model.Tag{{Name: "System", Value: "Sys"}, {Name: "UDE", Value: "U1"}, {Name: "UDE", Value: "U2"}}
+	if len(set) != len(want) {
+		t.Fatalf("set = %v, want %v", set, want)
+	}
+	for i := range want {
+		if set[i] != want[i] {
+			t.Errorf("tag %d = %v, want %v", i, set[i], want[i])
+		}
+	}
+
+	if set.Add(model.Tag{Name: "UDE", Value: "U1"}) {
+		t.Error("adding a tag already in the set reports an addition")
+	}
+	if got := set.Values("UDE"); len(got) != 2 || got[0] != "U1" || got[1] != "U2" {
+		t.Errorf("Values(UDE) = %v", got)
+	}
+	if got := set.Value("System"); got != "Sys" {
+		t.Errorf("Value(System) = %q", got)
+	}
+	if !set.RemoveName("UDE") || len(set) != 1 || set.RemoveName("UDE") {
+		t.Errorf("RemoveName(UDE) left %v", set)
+	}
+}
+
+func TestTagSetJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want string
+	}{
+		{"sorted and deduplicated", `[{"name":"b","value":"2"},{"name":"a","value":"1"},{"name":"b","value":"2"}]`, `[{"name":"a","value":"1"},{"name":"b","value":"2"}]`},
+		{"values converted", `[{"name":"n","value":12},{"name":"b","value":true}]`, `[{"name":"b","value":"true"},{"name":"n","value":"12"}]`},
+		{"missing value", `[{"name":"n"}]`, `[{"name":"n","value":""}]`},
+		{"null", `null`, `[]`},
+	}
+	for _, tt := range tests {
+		var set TagSet
+		if err := json.Unmarshal([]byte(tt.json), &set); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got, err := json.Marshal(set)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	var set TagSet
+	if err := json.Unmarshal([]byte(`[{"value":"x"}]`), &set); err == nil {
+		t.Error("a tag without name is accepted")
+	}
+}
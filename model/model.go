@@ -16,6 +16,13 @@ type Item struct {
 	EquipmentMeasurement string // Measurement of the equipment
 	UDE                  string // UDE (User-Defined Element)
 	SAPEquipmentID       string // SAP Equipment ID
+	ScaleFactor          string // Scale factor
+	Precision            string // Decimals of the values
+	LoLo                 string // Low low alarm limit
+	Lo                   string // Low alarm limit
+	Hi                   string // High alarm limit
+	HiHi                 string // High high alarm limit
+	Step                 string // Whether the values are steps, true or false
 	Tags                 []Tag  // all other tag we don't know yet
 }
 
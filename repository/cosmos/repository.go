@@ -4,48 +4,118 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"githb.com/Go-routine-4595/stream-ingest/config"
 	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
 	"net/http"
+	"sort"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
 	"github.com/rs/zerolog/log"
 )
 
+var _ repository.StreamRepository = Repository{}
+
 type Repository struct {
 	Client    *azcosmos.Client
 	Container *azcosmos.ContainerClient
+	retry     retryPolicy
+	stats     *statsCollector
 }
 
-func NewRespository() Repository {
+func NewRespository(cfg config.Cosmos) Repository {
 	// Create a credential
-	cred, err := azcosmos.NewKeyCredential(accountKey)
+	cred, err := azcosmos.NewKeyCredential(cfg.Key)
 	if err != nil {
 		log.Logger.Fatal().Msgf("Failed to create credentials: %v", err)
 	}
 
-	// Create a Cosmos DB client
-	client, err := azcosmos.NewClientWithKey(accountEndpoint, cred, nil)
+	// Create a Cosmos DB client, retries are done by the repository retryPolicy
+	// so the SDK ones are disabled
+	options := &azcosmos.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: -1},
+		},
+	}
+	client, err := azcosmos.NewClientWithKey(cfg.Endpoint, cred, options)
 	if err != nil {
 		log.Logger.Fatal().Msgf("Failed to create Cosmos DB client: %v", err)
 	}
 
 	// Specify the database and container
-	container, _ := client.NewContainer(databaseName, containerName)
+	container, _ := client.NewContainer(cfg.Database, cfg.Container)
 
 	return Repository{
 		Client:    client,
 		Container: container,
+		retry:     newRetryPolicy(cfg.Retry),
+		stats:     &statsCollector{},
+	}
+}
+
+// Stats returns the number of operations, attempts and request units used so far.
+func (r Repository) Stats() repository.Stats {
+	return r.stats.snapshot()
+}
+
+// queryStreams runs the query until every page is read, retrying the whole query on transient errors.
+func (r Repository) queryStreams(ctx context.Context, op string, id string, query string, partitionKey azcosmos.PartitionKey, queryOptions *azcosmos.QueryOptions) ([]stream.Stream, error) {
+	var streams []stream.Stream
+
+	err := r.queryItems(ctx, op, id, query, partitionKey, queryOptions, func() {
+		streams = make([]stream.Stream, 0)
+	}, func(item []byte) error {
+		var streamEl stream.Stream
+		if err := json.Unmarshal(item, &streamEl); err != nil {
+			return err
+		}
+		streams = append(streams, streamEl)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return streams, nil
+}
+
+// queryItems runs the query until every page is read, retrying the whole query on transient errors.
+// reset is called before each attempt and decode once per item read.
+func (r Repository) queryItems(ctx context.Context, op string, id string, query string, partitionKey azcosmos.PartitionKey, queryOptions *azcosmos.QueryOptions, reset func(), decode func([]byte) error) error {
+	attempts, charge, err := r.retry.do(ctx, func() (float32, error) {
+		var charge float32
+		reset()
+		pager := r.Container.NewQueryItemsPager(query, partitionKey, queryOptions)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return charge, err
+			}
+			charge += page.RequestCharge
+
+			for _, item := range page.Items {
+				if err = decode(item); err != nil {
+					return charge, errors.Join(errors.New("failed to unmarshal item"), err)
+				}
+			}
+		}
+		return charge, nil
+	})
+	r.stats.record(repository.ItemStats{Op: op, ID: id, Attempts: attempts, RequestCharge: charge, Err: err})
+	return err
 }
 
 // GetStreamByStreamIdAndSiteCode retrieves a stream from the repository using the provided stream ID. Returns the stream or an error.
 func (r Repository) GetStreamByStreamIdAndSiteCode(sensorId string, siteCode string) ([]stream.Stream, error) {
 	// Query items (example query: SELECT * FROM c WHERE c.id = '1')
 	// query := "SELECT * FROM c WHERE c.id = @id"
-	query := "SELECT * FROM c WHERE c.sensorId = @id"
+	query := "SELECT * FROM c WHERE c.sensorId = @id AND c.registryType = @registryType"
 	//query := "SELECT * FROM c"
 	params := []azcosmos.QueryParameter{
 		{Name: "@id", Value: sensorId},
+		{Name: "@registryType", Value: stream.RegistryTypeStream},
 	}
 
 	queryOptions := &azcosmos.QueryOptions{
@@ -57,24 +127,47 @@ func (r Repository) GetStreamByStreamIdAndSiteCode(sensorId string, siteCode str
 	// Define a context
 	ctx := context.TODO()
 
-	pager := r.Container.NewQueryItemsPager(query, partitionKey, queryOptions)
-	streams := make([]stream.Stream, 0)
+	streams, err := r.queryStreams(ctx, "read", sensorId, query, partitionKey, queryOptions)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to query stream %s in repository GetStreamByStreamIdAndSiteCode", sensorId), err)
+	}
+	return streams, nil
+}
 
-	for pager.More() {
-		page, err := pager.NextPage(ctx)
-		if err != nil {
-			log.Logger.Fatal().Msgf("Failed to query items: %v", err)
-		}
+// QueryStreams retrieves the streams matching the filter, sorted by SiteCode then SensorID.
+// Without SiteCode the query runs across every partition.
+func (r Repository) QueryStreams(filter repository.StreamFilter) ([]stream.Stream, error) {
+	query := "SELECT * FROM c WHERE c.registryType = @registryType"
+	params := []azcosmos.QueryParameter{
+		{Name: "@registryType", Value: stream.RegistryTypeStream},
+	}
+	partitionKey := azcosmos.NewPartitionKey()
+	if filter.SiteCode != "" {
+		partitionKey = azcosmos.NewPartitionKeyString(filter.SiteCode)
+	}
+	if filter.Process != "" {
+		query += " AND c.process = @process"
+		params = append(params, azcosmos.QueryParameter{Name: "@process", Value: filter.Process})
+	}
+	for i, tag := range filter.Tags {
+		query += fmt.Sprintf(" AND ARRAY_CONTAINS(c.tags, {\"name\": @tagName%d, \"value\": @tagValue%d})", i, i)
+		params = append(params,
+			azcosmos.QueryParameter{Name: fmt.Sprintf("@tagName%d", i), Value: tag.Name},
+			azcosmos.QueryParameter{Name: fmt.Sprintf("@tagValue%d", i), Value: tag.Value})
+	}
 
-		for _, item := range page.Items {
-			var streamEl stream.Stream
-			err = json.Unmarshal(item, &streamEl)
-			if err != nil {
-				log.Logger.Fatal().Msgf("Failed to unmarshal item: %v", err)
-			}
-			streams = append(streams, streamEl)
-		}
+	queryOptions := &azcosmos.QueryOptions{
+		QueryParameters: params,
 	}
+
+	// Define a context
+	ctx := context.TODO()
+
+	streams, err := r.queryStreams(ctx, "query", "", query, partitionKey, queryOptions)
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to query items in repository QueryStreams"), err)
+	}
+	repository.SortStreams(streams)
 	return streams, nil
 }
 
@@ -84,22 +177,80 @@ func (r Repository) UpdateStreamsByStreamKey(streams []stream.Stream) []error {
 	for _, streamEle := range streams {
 		itemData, err := json.Marshal(streamEle)
 		if err != nil {
-			//log.Logger.Debug().Msgf("Failed to marshal item: %v", err)
 			lerr := errors.Join(errors.New("failed to marshal item in repository UpdateStreamsByStreamKey"), err)
-			errs = append(errs, lerr)
+			errs = append(errs, repository.NewItemError(streamEle, 0, lerr))
+			continue
 		}
 		// create a context
 		ctx := context.TODO()
 
+		// only replace the document we read
+		var options *azcosmos.ItemOptions
+		if streamEle.ETag != "" {
+			etag := azcore.ETag(streamEle.ETag)
+			options = &azcosmos.ItemOptions{IfMatchEtag: &etag}
+		}
+
 		pk := azcosmos.NewPartitionKeyString(streamEle.SiteCode)
-		itemResponse, err := r.Container.ReplaceItem(ctx, pk, streamEle.ID, itemData, nil)
-		_ = itemResponse
+		tries := 0
+		attempts, charge, err := r.retry.do(ctx, func() (float32, error) {
+			tries++
+			itemResponse, err := r.Container.ReplaceItem(ctx, pk, streamEle.ID, itemData, options)
+			if tries > 1 && statusCode(err) == http.StatusPreconditionFailed && r.updatedByRun(ctx, pk, streamEle) {
+				// the attempt that timed out did replace the stream, changing its ETag
+				return itemResponse.RequestCharge, nil
+			}
+			return itemResponse.RequestCharge, err
+		})
+		r.stats.record(repository.ItemStats{Op: "replace", ID: streamEle.ID, SiteCode: streamEle.SiteCode, Attempts: attempts, RequestCharge: charge, Err: err})
 		if err != nil {
-			//log.Logger.Debug().Msgf("Failed to insert item: %v", err)
-			lerr := errors.Join(errors.New("failed to insert item in repository UpdateStreamsByStreamKey"), err)
-			errs = append(errs, lerr)
+			lerr := errors.Join(errors.New("failed to replace item in repository UpdateStreamsByStreamKey"), err)
+			if statusCode(err) == http.StatusPreconditionFailed {
+				lerr = errors.Join(lerr, repository.ErrPreconditionFailed)
+			}
+			errs = append(errs, repository.NewItemError(streamEle, statusCode(err), lerr))
+		}
+	}
+
+	return errs
+}
+
+func (r Repository) DeleteStreamsByStreamKey(streams []stream.Stream) []error {
+	var errs []error
+
+	for _, streamEle := range streams {
+		// create a context
+		ctx := context.TODO()
+
+		// only delete the document we read
+		var options *azcosmos.ItemOptions
+		if streamEle.ETag != "" {
+			etag := azcore.ETag(streamEle.ETag)
+			options = &azcosmos.ItemOptions{IfMatchEtag: &etag}
+		}
+
+		pk := azcosmos.NewPartitionKeyString(streamEle.SiteCode)
+		tries := 0
+		attempts, charge, err := r.retry.do(ctx, func() (float32, error) {
+			tries++
+			itemResponse, err := r.Container.DeleteItem(ctx, pk, streamEle.ID, options)
+			if tries > 1 && statusCode(err) == http.StatusNotFound {
+				// the attempt that timed out did delete the stream
+				return itemResponse.RequestCharge, nil
+			}
+			return itemResponse.RequestCharge, err
+		})
+		r.stats.record(repository.ItemStats{Op: "delete", ID: streamEle.ID, SiteCode: streamEle.SiteCode, Attempts: attempts, RequestCharge: charge, Err: err})
+		if err != nil {
+			lerr := errors.Join(errors.New("failed to delete item in repository DeleteStreamsByStreamKey"), err)
+			switch statusCode(err) {
+			case http.StatusPreconditionFailed:
+				lerr = errors.Join(lerr, repository.ErrPreconditionFailed)
+			case http.StatusNotFound:
+				lerr = errors.Join(lerr, repository.ErrNotFound)
+			}
+			errs = append(errs, repository.NewItemError(streamEle, statusCode(err), lerr))
 		}
-		//log.Logger.Debug().Msgf("Item created with ETag: %v\n", itemResponse.ETag)
 	}
 
 	return errs
@@ -111,108 +262,315 @@ func (r Repository) CreatStreamsByStreamKey(streams []stream.Stream) []error {
 	for _, streamEle := range streams {
 		itemData, err := json.Marshal(streamEle)
 		if err != nil {
-			//log.Logger.Debug().Msgf("Failed to marshal item: %v", err)
-			lerr := errors.Join(errors.New("failed to marshal item in repository UpdateStreamsByStreamKey"), err)
-			errs = append(errs, lerr)
+			lerr := errors.Join(errors.New("failed to marshal item in repository CreatStreamsByStreamKey"), err)
+			errs = append(errs, repository.NewItemError(streamEle, 0, lerr))
+			continue
 		}
 		// create a context
 		ctx := context.TODO()
 
 		pk := azcosmos.NewPartitionKeyString(streamEle.SiteCode)
-		itemResponse, err := r.Container.CreateItem(ctx, pk, itemData, nil)
-		_ = itemResponse
+		tries := 0
+		attempts, charge, err := r.retry.do(ctx, func() (float32, error) {
+			tries++
+			itemResponse, err := r.Container.CreateItem(ctx, pk, itemData, nil)
+			if tries > 1 && statusCode(err) == http.StatusConflict && r.createdByRun(ctx, pk, streamEle) {
+				// the attempt that timed out did create the stream
+				return itemResponse.RequestCharge, nil
+			}
+			return itemResponse.RequestCharge, err
+		})
+		r.stats.record(repository.ItemStats{Op: "create", ID: streamEle.ID, SiteCode: streamEle.SiteCode, Attempts: attempts, RequestCharge: charge, Err: err})
 		if err != nil {
-			//log.Logger.Debug().Msgf("Failed to insert item: %v", err)
-			lerr := errors.Join(errors.New("failed to insert item in repository UpdateStreamsByStreamKey"), err)
-			errs = append(errs, lerr)
+			lerr := errors.Join(errors.New("failed to insert item in repository CreatStreamsByStreamKey"), err)
+			errs = append(errs, repository.NewItemError(streamEle, statusCode(err), lerr))
 		}
-		//log.Logger.Debug().Msgf("Item created with ETag: %v\n", itemResponse.ETag)
 	}
 
 	return errs
 }
 
-func (r Repository) CreatBatchedStreamsByStreamKey(streams []stream.Stream) []error {
+// createdByRun tells if the stored document of s is s written by the same run.
+func (r Repository) createdByRun(ctx context.Context, pk azcosmos.PartitionKey, s stream.Stream) bool {
+	stored, ok := r.readStored(ctx, pk, s.ID)
+	return ok && stored.ID == s.ID && stored.RunID == s.RunID
+}
+
+// updatedByRun tells if the stored document of s is the version of s written by the same run.
+func (r Repository) updatedByRun(ctx context.Context, pk azcosmos.PartitionKey, s stream.Stream) bool {
+	stored, ok := r.readStored(ctx, pk, s.ID)
+	return ok && stored.ID == s.ID && stored.RunID == s.RunID && stored.Version == s.Version
+}
+
+// readStored reads the stored document id, false when it cannot be read.
+func (r Repository) readStored(ctx context.Context, pk azcosmos.PartitionKey, id string) (stream.Stream, bool) {
+	var stored stream.Stream
+	itemResponse, err := r.Container.ReadItem(ctx, pk, id, nil)
+	if err != nil {
+		return stored, false
+	}
+	if err = json.Unmarshal(itemResponse.Value, &stored); err != nil {
+		return stored, false
+	}
+	return stored, true
+}
+
+// CreateHistory upserts the history entries one by one, so recording the same version twice is harmless.
+func (r Repository) CreateHistory(entries []stream.History) []error {
 	var errs []error
 
-	batches, _, err := makeBatches(streams, 100)
+	for _, entry := range entries {
+		itemData, err := json.Marshal(entry)
+		if err != nil {
+			lerr := errors.Join(errors.New("failed to marshal item in repository CreateHistory"), err)
+			errs = append(errs, repository.NewItemError(entry.Stream, 0, lerr))
+			continue
+		}
+		// create a context
+		ctx := context.TODO()
+
+		pk := azcosmos.NewPartitionKeyString(entry.SiteCode)
+		attempts, charge, err := r.retry.do(ctx, func() (float32, error) {
+			itemResponse, err := r.Container.UpsertItem(ctx, pk, itemData, nil)
+			return itemResponse.RequestCharge, err
+		})
+		r.stats.record(repository.ItemStats{Op: "history", ID: entry.ID, SiteCode: entry.SiteCode, Attempts: attempts, RequestCharge: charge, Err: err})
+		if err != nil {
+			lerr := errors.Join(errors.New("failed to upsert item in repository CreateHistory"), err)
+			errs = append(errs, repository.NewItemError(entry.Stream, statusCode(err), lerr))
+		}
+	}
+
+	return errs
+}
+
+// GetHistory retrieves the previous versions of the stream streamID of the siteCode partition sorted by Version.
+func (r Repository) GetHistory(streamID string, siteCode string) ([]stream.History, error) {
+	query := "SELECT * FROM c WHERE c.registryType = @registryType AND c.streamId = @streamId"
+	queryOptions := &azcosmos.QueryOptions{
+		QueryParameters: []azcosmos.QueryParameter{
+			{Name: "@registryType", Value: stream.RegistryTypeStreamHistory},
+			{Name: "@streamId", Value: streamID},
+		},
+	}
+
+	// Define a context
+	ctx := context.TODO()
+
+	var entries []stream.History
+	err := r.queryItems(ctx, "history", streamID, query, azcosmos.NewPartitionKeyString(siteCode), queryOptions, func() {
+		entries = make([]stream.History, 0)
+	}, func(item []byte) error {
+		var entry stream.History
+		if err := json.Unmarshal(item, &entry); err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
 	if err != nil {
-		return []error{err}
+		return nil, errors.Join(fmt.Errorf("failed to query history of %s in repository GetHistory", streamID), err)
+	}
+	repository.SortHistory(entries)
+	return entries, nil
+}
+
+// TagNames retrieves the sorted names of the tags held by at least one stream, across every partition.
+// The names are made unique here since DISTINCT is not supported by cross partition queries of the SDK.
+func (r Repository) TagNames() ([]string, error) {
+	query := "SELECT VALUE t.name FROM c JOIN t IN c.tags WHERE c.registryType = @registryType"
+	queryOptions := &azcosmos.QueryOptions{
+		QueryParameters: []azcosmos.QueryParameter{
+			{Name: "@registryType", Value: stream.RegistryTypeStream},
+		},
 	}
 
-	for site, batch := range batches {
-		pk := azcosmos.NewPartitionKeyString(site)
+	// Define a context
+	ctx := context.TODO()
+
+	var names []string
+	err := r.queryItems(ctx, "tags", "", query, azcosmos.NewPartitionKey(), queryOptions, func() {
+		names = make([]string, 0)
+	}, func(item []byte) error {
+		var name string
+		if err := json.Unmarshal(item, &name); err != nil {
+			return err
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to query tag names in repository TagNames"), err)
+	}
+	return repository.UniqueNames(names), nil
+}
+
+// WriteBatchedStreamsByStreamKey writes the streams with transactional batches: one or more batches
+// per SiteCode partition, each within the Cosmos limits of 100 operations and 2 MB.
+// A batch is all or nothing, so when an operation fails every stream of its batch gets an
+// ItemError: the failing one with its own status code, the others with http.StatusFailedDependency.
+func (r Repository) WriteBatchedStreamsByStreamKey(op repository.BatchOperation, streams []stream.Stream) []error {
+	var errs []error
+
+	batches, batchErrs := makeBatches(streams, maxBatchOperations, maxBatchBytes)
+	errs = append(errs, batchErrs...)
+
+	for _, batch := range batches {
+		pk := azcosmos.NewPartitionKeyString(batch.siteCode)
 		batchDB := r.Container.NewTransactionalBatch(pk)
-		for _, item := range batch {
-			batchDB.CreateItem(item, nil)
+		for i, item := range batch.items {
+			switch op {
+			case repository.BatchCreate:
+				batchDB.CreateItem(item, nil)
+			case repository.BatchUpsert:
+				batchDB.UpsertItem(item, nil)
+			case repository.BatchReplace:
+				var options *azcosmos.TransactionalBatchItemOptions
+				if etag := azcore.ETag(batch.streams[i].ETag); etag != "" {
+					options = &azcosmos.TransactionalBatchItemOptions{IfMatchETag: &etag}
+				}
+				batchDB.ReplaceItem(batch.streams[i].ID, item, options)
+			default:
+				return append(errs, fmt.Errorf("unknown batch operation %s", op))
+			}
 		}
 
 		ctx := context.TODO()
 
-		resp, err := r.Container.ExecuteTransactionalBatch(ctx, batchDB, nil)
+		var resp azcosmos.TransactionalBatchResponse
+		attempts, charge, err := r.retry.do(ctx, func() (float32, error) {
+			var err error
+			resp, err = r.Container.ExecuteTransactionalBatch(ctx, batchDB, nil)
+			if err == nil && !resp.Success {
+				// a throttled operation makes the whole batch fail, retry it as a throttled request
+				if _, status := batchFailure(resp); isTransientStatus(status) {
+					err = &azcore.ResponseError{StatusCode: status, RawResponse: resp.RawResponse}
+				}
+			}
+			return resp.RequestCharge, err
+		})
+		r.stats.record(repository.ItemStats{Op: "batch " + string(op), SiteCode: batch.siteCode, Attempts: attempts, RequestCharge: charge, Err: err})
 		if err != nil {
-			return []error{err}
+			lerr := errors.Join(fmt.Errorf("failed to execute batch in repository WriteBatchedStreamsByStreamKey"), err)
+			for _, streamEle := range batch.streams {
+				errs = append(errs, repository.NewItemError(streamEle, statusCode(err), lerr))
+			}
+			continue
+		}
+		if resp.Success {
+			continue
 		}
-		for _, op := range resp.OperationResults {
-			if op.StatusCode != http.StatusCreated {
-				lerr := errors.Join(errors.New("failed to create item in repository CreatBatchedStreamsByStreamKey"), err)
-				errs = append(errs, lerr)
+		cause, status := batchFailure(resp)
+		if cause < 0 {
+			// no operation tells why the batch failed
+			if resp.RawResponse != nil {
+				status = resp.RawResponse.StatusCode
 			}
+			lerr := fmt.Errorf("failed to execute batch in repository WriteBatchedStreamsByStreamKey: status %d", status)
+			for _, streamEle := range batch.streams {
+				errs = append(errs, repository.NewItemError(streamEle, status, lerr))
+			}
+			continue
+		}
+		for i, streamEle := range batch.streams {
+			if i == cause {
+				lerr := fmt.Errorf("failed to %s item in repository WriteBatchedStreamsByStreamKey: status %d", op, status)
+				if status == http.StatusPreconditionFailed {
+					lerr = errors.Join(lerr, repository.ErrPreconditionFailed)
+				}
+				errs = append(errs, repository.NewItemError(streamEle, status, lerr))
+				continue
+			}
+			lerr := fmt.Errorf("item not written in repository WriteBatchedStreamsByStreamKey: batch rolled back because of %s", batch.streams[cause].ID)
+			errs = append(errs, repository.NewItemError(streamEle, http.StatusFailedDependency, lerr))
 		}
 	}
 
 	return errs
 }
 
-/ makeBatches groups a slice of streams by siteCode, splits them into smaller batches of the specified size, and returns:
-// - A map[string][][]byte where the key is siteCode and the value is a set of marshaled batches.
-// - A map[string][][]string where the key is siteCode and the value is a set of batches containing stream IDs.
-func makeBatches(streams []stream.Stream, batchSize int) (map[string][][]byte, map[string][][]string, error) {
+// Cosmos transactional batch limits
+const (
+	maxBatchOperations = 100
+	// 2 MB less a margin for the batch envelope
+	maxBatchBytes = 2*1024*1024 - 64*1024
+)
+
+// batch is a set of streams of the same partition written in one transactional batch
+type batch struct {
+	siteCode string
+	streams  []stream.Stream
+	items    [][]byte // marshaled streams, in the same order
+}
+
+// makeBatches groups the streams by siteCode, keeping their order, and splits each group into
+// batches holding at most maxOperations streams and maxBytes of marshaled documents.
+// Batches are returned sorted by siteCode. Streams that cannot be marshaled or are too large
+// for a batch are returned as errors.
+func makeBatches(streams []stream.Stream, maxOperations int, maxBytes int) ([]batch, []error) {
+	var errs []error
+
 	// Step 1: Group streams by siteCode
 	grouped := make(map[string][]stream.Stream)
+	var sites []string
 	for _, streamItem := range streams {
+		if _, ok := grouped[streamItem.SiteCode]; !ok {
+			sites = append(sites, streamItem.SiteCode)
+		}
 		grouped[streamItem.SiteCode] = append(grouped[streamItem.SiteCode], streamItem)
 	}
-
-	marshaledResults := make(map[string][][]byte)
-	idsResults := make(map[string][][]string)
+	sort.Strings(sites)
 
 	// Step 2: Create batches for each siteCode
-	for siteCode, siteStreams := range grouped {
-		var marshaledBatches [][]byte
-		var idBatches [][]string
-
-		for i := 0; i < len(siteStreams); i += batchSize {
-			end := i + batchSize
-			if end > len(siteStreams) {
-				end = len(siteStreams)
+	var batches []batch
+	for _, siteCode := range sites {
+		current := batch{siteCode: siteCode}
+		size := 0
+		for _, streamItem := range grouped[siteCode] {
+			item, err := json.Marshal(streamItem)
+			if err != nil {
+				errs = append(errs, repository.NewItemError(streamItem, 0, errors.Join(errors.New("failed to marshal item in repository makeBatches"), err)))
+				continue
 			}
-			batch := siteStreams[i:end]
-
-			// Step 3a: Marshal the batch
-			for _, item := range batch {
-
-				marshaledBatch, err := json.Marshal(item)
-				if err != nil {
-					return nil, nil, err
-				}
-				marshaledBatches = append(marshaledBatches, marshaledBatch)
+			if len(item) > maxBytes {
+				errs = append(errs, repository.NewItemError(streamItem, http.StatusRequestEntityTooLarge, fmt.Errorf("item of %d bytes is too large for a batch", len(item))))
+				continue
 			}
-
-			// Step 3b: Extract IDs from the batch
-			var idBatch []string
-			for _, item := range batch {
-				idBatch = append(idBatch, item.ID)
+			if len(current.items) == maxOperations || size+len(item) > maxBytes {
+				batches = append(batches, current)
+				current = batch{siteCode: siteCode}
+				size = 0
 			}
-			idBatches = append(idBatches, idBatch)
+			current.streams = append(current.streams, streamItem)
+			current.items = append(current.items, item)
+			size += len(item)
+		}
+		if len(current.items) > 0 {
+			batches = append(batches, current)
 		}
+	}
+
+	return batches, errs
+}
 
-		// Step 4: Store results in maps
-		marshaledResults[siteCode] = marshaledBatches
-		idsResults[siteCode] = idBatches
+// batchFailure returns the index and the status code of the operation that made the batch fail,
+// -1 when no operation failed by itself.
+func batchFailure(resp azcosmos.TransactionalBatchResponse) (int, int) {
+	for i, op := range resp.OperationResults {
+		if op.StatusCode != http.StatusFailedDependency && (op.StatusCode < 200 || op.StatusCode >= 300) {
+			return i, int(op.StatusCode)
+		}
 	}
+	return -1, 0
+}
 
-	return marshaledResults, idsResults, nil
+// statusCode returns the HTTP status of a failed request, 0 when unknown.
+func statusCode(err error) int {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode
+	}
+	return 0
 }
 
 func (r Repository) Close() {
@@ -0,0 +1,117 @@
+package cosmos
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+)
+
+func newStream(id string, siteCode string) stream.Stream {
+	s := stream.NewStream()
+	s.ID, s.SiteCode, s.SensorID = id, siteCode, "T"+id
+	return s
+}
+
+func TestMakeBatches(t *testing.T) {
+	big := newStream("big", "S1")
+	big.StreamName = strings.Repeat("x", 2000)
+	streams := []stream.Stream{
+		newStream("1", "S2"),
+		newStream("2", "S1"),
+		newStream("3", "S2"),
+		newStream("4", "S1"),
+		newStream("5", "S1"),
+		big,
+	}
+	item, err := json.Marshal(streams[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := len(item) // every stream but big has this size
+
+	tests := []struct {
+		name          string
+		maxOperations int
+		maxBytes      int
+		want          [][]string // IDs of each batch
+		wantTooLarge  []string
+	}{
+		{"one batch per site", 100, 3 * size, [][]string{{"2", "4", "5"}, {"1", "3"}}, []string{"big"}},
+		{"operations limit", 2, 3 * size, [][]string{{"2", "4"}, {"5"}, {"1", "3"}}, []string{"big"}},
+		{"size limit", 100, 2*size - 1, [][]string{{"2"}, {"4"}, {"5"}, {"1"}, {"3"}}, []string{"big"}},
+		{"large enough", 100, 1 << 20, [][]string{{"2", "4", "5", "big"}, {"1", "3"}}, nil},
+	}
+	for _, tt := range tests {
+		batches, errs := makeBatches(streams, tt.maxOperations, tt.maxBytes)
+		var got [][]string
+		for _, b := range batches {
+			var ids []string
+			for i, s := range b.streams {
+				if s.SiteCode != b.siteCode {
+					t.Errorf("%s: stream %s of site %s in a batch of %s", tt.name, s.ID, s.SiteCode, b.siteCode)
+				}
+				if len(b.items[i]) == 0 {
+					t.Errorf("%s: stream %s not marshaled", tt.name, s.ID)
+				}
+				ids = append(ids, s.ID)
+			}
+			got = append(got, ids)
+		}
+		if strings.Join(flatten(got), " ") != strings.Join(flatten(tt.want), " ") || len(got) != len(tt.want) {
+			t.Errorf("%s: batches = %v, want %v", tt.name, got, tt.want)
+		}
+		var tooLarge []string
+		for _, err := range errs {
+			var itemErr *repository.ItemError
+			if !errors.As(err, &itemErr) || itemErr.StatusCode != http.StatusRequestEntityTooLarge {
+				t.Errorf("%s: error %v, want a too large ItemError", tt.name, err)
+				continue
+			}
+			tooLarge = append(tooLarge, itemErr.ID)
+		}
+		if strings.Join(tooLarge, " ") != strings.Join(tt.wantTooLarge, " ") {
+			t.Errorf("%s: too large = %v, want %v", tt.name, tooLarge, tt.wantTooLarge)
+		}
+	}
+}
+
+func flatten(batches [][]string) []string {
+	var ids []string
+	for _, b := range batches {
+		ids = append(ids, b...)
+	}
+	return ids
+}
+
+func TestBatchFailure(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int32
+		wantCause  int
+		wantStatus int
+	}{
+		{"success", []int32{http.StatusCreated, http.StatusOK}, -1, 0},
+		{"first fails", []int32{http.StatusConflict, http.StatusFailedDependency, http.StatusFailedDependency}, 0, http.StatusConflict},
+		{"middle fails", []int32{http.StatusFailedDependency, http.StatusPreconditionFailed, http.StatusFailedDependency}, 1, http.StatusPreconditionFailed},
+		{"throttled", []int32{http.StatusFailedDependency, http.StatusTooManyRequests}, 1, http.StatusTooManyRequests},
+		{"no cause", []int32{http.StatusFailedDependency, http.StatusFailedDependency}, -1, 0},
+		{"no result", nil, -1, 0},
+	}
+	for _, tt := range tests {
+		var resp azcosmos.TransactionalBatchResponse
+		for _, status := range tt.statuses {
+			resp.OperationResults = append(resp.OperationResults, azcosmos.TransactionalBatchResult{StatusCode: status})
+		}
+		cause, status := batchFailure(resp)
+		if cause != tt.wantCause || status != tt.wantStatus {
+			t.Errorf("%s: batchFailure = %d, %d, want %d, %d", tt.name, cause, status, tt.wantCause, tt.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package cosmos
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"githb.com/Go-routine-4595/stream-ingest/config"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// Default retry settings, used when the configuration leaves them to zero.
+const (
+	defaultMaxAttempts = 6
+	defaultBaseDelay   = 100 * time.Millisecond
+	defaultMaxDelay    = 5 * time.Second
+	defaultBudget      = 30 * time.Second
+)
+
+const (
+	headerRetryAfterMs  = "x-ms-retry-after-ms"
+	headerRequestCharge = "x-ms-request-charge"
+)
+
+// retryPolicy retries throttled and transient requests with a jittered exponential
+// backoff, or after the delay asked by Cosmos in x-ms-retry-after-ms.
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	budget      time.Duration
+}
+
+func newRetryPolicy(cfg config.Retry) retryPolicy {
+	p := retryPolicy{
+		maxAttempts: cfg.MaxAttempts,
+		baseDelay:   time.Duration(cfg.BaseDelayMs) * time.Millisecond,
+		maxDelay:    time.Duration(cfg.MaxDelayMs) * time.Millisecond,
+		budget:      time.Duration(cfg.BudgetMs) * time.Millisecond,
+	}
+	if p.maxAttempts == 0 {
+		p.maxAttempts = defaultMaxAttempts
+	}
+	if p.baseDelay == 0 {
+		p.baseDelay = defaultBaseDelay
+	}
+	if p.maxDelay == 0 {
+		p.maxDelay = defaultMaxDelay
+	}
+	if p.budget == 0 {
+		p.budget = defaultBudget
+	}
+	return p
+}
+
+// do runs fn until it succeeds, fails with an error that is not transient, or the attempts
+// or the waiting budget are exhausted. fn returns the request charge of its attempt.
+func (p retryPolicy) do(ctx context.Context, fn func() (float32, error)) (attempts int, charge float64, err error) {
+	var waited time.Duration
+
+	for attempts = 1; ; attempts++ {
+		var c float32
+		c, err = fn()
+		if err != nil {
+			c = errorRequestCharge(err)
+		}
+		charge += float64(c)
+		if err == nil || !isTransient(err) || attempts >= p.maxAttempts {
+			return attempts, charge, err
+		}
+
+		delay := p.delay(attempts, err)
+		if waited+delay > p.budget {
+			return attempts, charge, err
+		}
+		waited += delay
+
+		select {
+		case <-ctx.Done():
+			return attempts, charge, errors.Join(err, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// delay returns how long to wait before the next attempt: the delay asked by Cosmos
+// when present, otherwise baseDelay doubled on each attempt, capped to maxDelay, with
+// a random jitter keeping it between half and the full value.
+func (p retryPolicy) delay(attempt int, err error) time.Duration {
+	if after := retryAfter(err); after > 0 {
+		return after
+	}
+	d := p.baseDelay << (attempt - 1)
+	if d <= 0 || d > p.maxDelay {
+		d = p.maxDelay
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// isTransient reports whether the request failed because of throttling or a transient outage.
+func isTransient(err error) bool {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) {
+		return false
+	}
+	return isTransientStatus(respErr.StatusCode)
+}
+
+func isTransientStatus(status int) bool {
+	return status == http.StatusTooManyRequests ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusRequestTimeout
+}
+
+func retryAfter(err error) time.Duration {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) || respErr.RawResponse == nil {
+		return 0
+	}
+	ms, perr := strconv.ParseFloat(respErr.RawResponse.Header.Get(headerRetryAfterMs), 64)
+	if perr != nil || ms <= 0 {
+		return 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+func errorRequestCharge(err error) float32 {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) || respErr.RawResponse == nil {
+		return 0
+	}
+	charge, perr := strconv.ParseFloat(respErr.RawResponse.Header.Get(headerRequestCharge), 32)
+	if perr != nil {
+		return 0
+	}
+	return float32(charge)
+}
+
+// statsCollector accumulates the repository Stats, it is shared by the copies of a Repository.
+type statsCollector struct {
+	mu    sync.Mutex
+	stats repository.Stats
+}
+
+func (c *statsCollector) record(item repository.ItemStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Operations++
+	c.stats.Attempts += item.Attempts
+	c.stats.RequestCharge += item.RequestCharge
+	if item.Attempts > 1 {
+		c.stats.Retried = append(c.stats.Retried, item)
+	}
+}
+
+func (c *statsCollector) snapshot() repository.Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.stats
+	s.Retried = append([]repository.ItemStats(nil), c.stats.Retried...)
+	return s
+}
@@ -0,0 +1,233 @@
+package cosmos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"githb.com/Go-routine-4595/stream-ingest/config"
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// responseError returns the error of a Cosmos response with the given status and headers.
+func responseError(status int, headers map[string]string) error {
+	resp := &http.Response{StatusCode: status, Header: http.Header{}}
+	for name, value := range headers {
+		resp.Header.Set(name, value)
+	}
+	return &azcore.ResponseError{StatusCode: status, RawResponse: resp}
+}
+
+func TestNewRetryPolicy(t *testing.T) {
+	p := newRetryPolicy(config.Retry{})
+	if p.maxAttempts != defaultMaxAttempts || p.baseDelay != defaultBaseDelay || p.maxDelay != defaultMaxDelay || p.budget != defaultBudget {
+		t.Errorf("defaults = %+v", p)
+	}
+	p = newRetryPolicy(config.Retry{MaxAttempts: 2, BaseDelayMs: 10, MaxDelayMs: 20, BudgetMs: 30})
+	if p.maxAttempts != 2 || p.baseDelay != 10*time.Millisecond || p.maxDelay != 20*time.Millisecond || p.budget != 30*time.Millisecond {
+		t.Errorf("settings = %+v", p)
+	}
+}
+
+func TestRetryDo(t *testing.T) {
+	throttled := responseError(http.StatusTooManyRequests, map[string]string{headerRetryAfterMs: "1", headerRequestCharge: "0.5"})
+	tests := []struct {
+		name         string
+		results      []error // result of each attempt, nil once the list is exhausted
+		policy       retryPolicy
+		wantAttempts int
+		wantErr      bool
+		wantStatus   int // status of the error returned
+	}{
+		{"success", nil, retryPolicy{maxAttempts: 3, baseDelay: time.Millisecond, maxDelay: time.Millisecond, budget: time.Second}, 1, false, 0},
+		{"throttled then success", []error{throttled, throttled}, retryPolicy{maxAttempts: 3, baseDelay: time.Millisecond, maxDelay: time.Millisecond, budget: time.Second}, 3, false, 0},
+		{"unavailable then success", []error{responseError(http.StatusServiceUnavailable, nil)}, retryPolicy{maxAttempts: 3, baseDelay: time.Millisecond, maxDelay: time.Millisecond, budget: time.Second}, 2, false, 0},
+		{"attempts exhausted", []error{throttled, throttled, throttled}, retryPolicy{maxAttempts: 2, baseDelay: time.Millisecond, maxDelay: time.Millisecond, budget: time.Second}, 2, true, http.StatusTooManyRequests},
+		{"budget exhausted", []error{responseError(http.StatusTooManyRequests, map[string]string{headerRetryAfterMs: "50"})}, retryPolicy{maxAttempts: 5, baseDelay: time.Millisecond, maxDelay: time.Millisecond, budget: 10 * time.Millisecond}, 1, true, http.StatusTooManyRequests},
+		{"not transient", []error{responseError(http.StatusConflict, nil)}, retryPolicy{maxAttempts: 5, baseDelay: time.Millisecond, maxDelay: time.Millisecond, budget: time.Second}, 1, true, http.StatusConflict},
+		{"not a response", []error{errors.New("dial failed")}, retryPolicy{maxAttempts: 5, baseDelay: time.Millisecond, maxDelay: time.Millisecond, budget: time.Second}, 1, true, 0},
+	}
+	for _, tt := range tests {
+		calls := 0
+		attempts, charge, err := tt.policy.do(context.Background(), func() (float32, error) {
+			calls++
+			if calls <= len(tt.results) && tt.results[calls-1] != nil {
+				return 0, tt.results[calls-1]
+			}
+			return 1, nil
+		})
+		if attempts != tt.wantAttempts || calls != tt.wantAttempts {
+			t.Errorf("%s: %d attempts, %d calls, want %d", tt.name, attempts, calls, tt.wantAttempts)
+		}
+		if (err != nil) != tt.wantErr || statusCode(err) != tt.wantStatus {
+			t.Errorf("%s: error = %v, want status %d", tt.name, err, tt.wantStatus)
+		}
+		if tt.name == "throttled then success" && charge != 2 {
+			t.Errorf("%s: charge = %v, want the charge of every attempt", tt.name, charge)
+		}
+	}
+}
+
+func TestRetryDoCanceled(t *testing.T) {
+	p := retryPolicy{maxAttempts: 5, baseDelay: time.Second, maxDelay: time.Second, budget: time.Minute}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := p.do(ctx, func() (float32, error) {
+		return 0, responseError(http.StatusServiceUnavailable, nil)
+	})
+	if !errors.Is(err, context.Canceled) || statusCode(err) != http.StatusServiceUnavailable {
+		t.Errorf("error = %v, want the request error and the cancellation", err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := retryPolicy{baseDelay: 100 * time.Millisecond, maxDelay: time.Second}
+	tests := []struct {
+		attempt  int
+		err      error
+		min, max time.Duration
+	}{
+		{1, responseError(http.StatusTooManyRequests, map[string]string{headerRetryAfterMs: "250"}), 250 * time.Millisecond, 250 * time.Millisecond},
+		{1, responseError(http.StatusTooManyRequests, map[string]string{headerRetryAfterMs: "0.5"}), 500 * time.Microsecond, 500 * time.Microsecond},
+		{1, responseError(http.StatusTooManyRequests, map[string]string{headerRetryAfterMs: "abc"}), 50 * time.Millisecond, 100 * time.Millisecond},
+		{1, responseError(http.StatusServiceUnavailable, nil), 50 * time.Millisecond, 100 * time.Millisecond},
+		{3, responseError(http.StatusServiceUnavailable, nil), 200 * time.Millisecond, 400 * time.Millisecond},
+		{10, responseError(http.StatusServiceUnavailable, nil), 500 * time.Millisecond, time.Second},
+		{70, responseError(http.StatusServiceUnavailable, nil), 500 * time.Millisecond, time.Second}, // the shift overflows
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if d := p.delay(tt.attempt, tt.err); d < tt.min || d > tt.max {
+				t.Errorf("delay(%d, %v) = %v, want between %v and %v", tt.attempt, tt.err, d, tt.min, tt.max)
+				break
+			}
+		}
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for status, want := range map[int]bool{
+		http.StatusTooManyRequests:     true,
+		http.StatusServiceUnavailable:  true,
+		http.StatusRequestTimeout:      true,
+		http.StatusConflict:            false,
+		http.StatusPreconditionFailed:  false,
+		http.StatusInternalServerError: false,
+	} {
+		if got := isTransient(responseError(status, nil)); got != want {
+			t.Errorf("isTransient(%d) = %v, want %v", status, got, want)
+		}
+	}
+	if isTransient(errors.New("other")) {
+		t.Error("an error without status is transient")
+	}
+}
+
+func TestStatsCollector(t *testing.T) {
+	c := &statsCollector{}
+	c.record(repository.ItemStats{Op: "create", ID: "1", Attempts: 1, RequestCharge: 5})
+	c.record(repository.ItemStats{Op: "create", ID: "2", Attempts: 3, RequestCharge: 7.5})
+	s := c.snapshot()
+	if s.Operations != 2 || s.Attempts != 4 || s.RequestCharge != 12.5 || len(s.Retried) != 1 || s.Retried[0].ID != "2" {
+		t.Errorf("stats = %+v", s)
+	}
+	s.Retried[0].ID = "changed"
+	if c.snapshot().Retried[0].ID != "2" {
+		t.Error("the snapshot shares the retried list")
+	}
+}
+
+// fakeCosmos is a Cosmos DB endpoint whose first replace or delete of a document times out (408),
+// after applying it when applied is set, as a request whose response is lost.
+type fakeCosmos struct {
+	mu      sync.Mutex
+	applied bool
+	docs    map[string][]byte
+	calls   map[string]int // requests by method and document ID
+}
+
+func (f *fakeCosmos) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	const docs = "/dbs/db/colls/c/docs/"
+	if !strings.HasPrefix(req.URL.Path, docs) {
+		// account properties
+		w.Write([]byte("{}"))
+		return
+	}
+	id := strings.TrimPrefix(req.URL.Path, docs)
+	f.calls[req.Method+" "+id]++
+	first := f.calls[req.Method+" "+id] == 1
+	switch req.Method {
+	case http.MethodGet:
+		doc, ok := f.docs[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(doc)
+	case http.MethodPut:
+		if !first {
+			// the ETag read changed with the first replace
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if f.applied {
+			f.docs[id], _ = io.ReadAll(req.Body)
+		}
+		w.WriteHeader(http.StatusRequestTimeout)
+	case http.MethodDelete:
+		if _, ok := f.docs[id]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if f.applied {
+			delete(f.docs, id)
+		}
+		w.WriteHeader(http.StatusRequestTimeout)
+	}
+}
+
+func TestRetriedWrites(t *testing.T) {
+	stored := stream.NewStream()
+	stored.SiteCode, stored.SensorID, stored.RunID = "S1", "T1", "run-a"
+	written := stored
+	written.RunID, written.Version, written.ETag = "run-b", 2, "etag-1"
+
+	for _, applied := range []bool{true, false} {
+		doc, _ := json.Marshal(stored)
+		fake := &fakeCosmos{applied: applied, docs: map[string][]byte{stored.ID: doc}, calls: map[string]int{}}
+		srv := httptest.NewServer(fake)
+		r := NewRespository(config.Cosmos{Endpoint: srv.URL, Key: "a2V5", Database: "db", Container: "c",
+			Retry: config.Retry{MaxAttempts: 3, BaseDelayMs: 1, MaxDelayMs: 1, BudgetMs: 1000}})
+
+		// a retried replace fails with 412 as the first attempt changed the ETag
+		errs := r.UpdateStreamsByStreamKey([]stream.Stream{written})
+		if applied && len(errs) != 0 {
+			t.Errorf("replace applied by the first attempt: %v, want success", errs)
+		}
+		if !applied && (len(errs) != 1 || !errors.Is(errs[0], repository.ErrPreconditionFailed)) {
+			t.Errorf("replace of a stream written by another run: %v, want a precondition failure", errs)
+		}
+		if fake.calls["PUT "+stored.ID] != 2 {
+			t.Errorf("%d replace attempts, want 2", fake.calls["PUT "+stored.ID])
+		}
+
+		// a retried delete fails with 404 as the first attempt deleted the stream
+		errs = r.DeleteStreamsByStreamKey([]stream.Stream{written})
+		if applied && len(errs) != 0 {
+			t.Errorf("delete applied by the first attempt: %v, want success", errs)
+		}
+		srv.Close()
+	}
+}
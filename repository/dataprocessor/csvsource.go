@@ -0,0 +1,61 @@
+package dataprocessor
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	errSheetOnCSV     = errors.New("a sheet can only be selected in a workbook (.xlsx)")
+	errEncodingOnXLSX = errors.New("an encoding can only be given for a text file (csv, json, jsonl)")
+)
+
+// csvSource reads the records of a CSV file. Records are numbered by the line they start on,
+// a quoted field may hold several lines and blank lines are skipped.
+type csvSource struct {
+	name   string
+	file   io.ReadCloser
+	reader *csv.Reader
+	row    int // line of the last record read
+}
+
+func newCSVSource(name string, file io.ReadCloser) *csvSource {
+	reader := csv.NewReader(file)
+	// rows above the header may have another number of cells than the header
+	reader.FieldsPerRecord = -1
+	return &csvSource{name: name, file: file, reader: reader}
+}
+
+func (s *csvSource) Read() ([]string, int, error) {
+	record, err := s.reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, 0, io.EOF
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			s.row = parseErr.StartLine
+			return nil, s.row, err
+		}
+		return nil, s.row + 1, err
+	}
+	s.row, _ = s.reader.FieldPos(0)
+	return record, s.row, nil
+}
+
+func (s *csvSource) Position(row int, col int) string {
+	if col < 0 {
+		return fmt.Sprintf("line %d", row)
+	}
+	return fmt.Sprintf("line %d, column %d", row, col+1)
+}
+
+func (s *csvSource) Name() string {
+	return s.name
+}
+
+func (s *csvSource) Close() error {
+	return s.file.Close()
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"sort"
+	"strings"
 
 	"githb.com/Go-routine-4595/stream-ingest/model"
 
@@ -34,8 +36,12 @@ func (p CSVPersist) Persist(items []model.Item) error {
 	writer := csv.NewWriter(p.file)
 	defer writer.Flush()
 
+	// Tags without a column of their own get an extra column
+	extraHeaders := extraTagHeaders(items)
+
 	// Write the headers to the CSV file
-	if err := writer.Write(expectedHeaders); err != nil {
+	headers := append(defaultMapping.Names(), extraHeaders...)
+	if err := writer.Write(headers); err != nil {
 		log.Logger.Err(err).Msg("failed to write headers")
 		return NewCSVReaderError("failed to write headers", err)
 	}
@@ -44,7 +50,7 @@ func (p CSVPersist) Persist(items []model.Item) error {
 	var rowError bool
 	rowError = false
 	for _, item := range items {
-		row := itemToString(item)
+		row := itemToString(item, extraHeaders)
 		if err := writer.Write(row); err != nil {
 			log.Logger.Err(err).Msgf("failed to write row: %s", row)
 			rowError = true
@@ -56,14 +62,48 @@ func (p CSVPersist) Persist(items []model.Item) error {
 	return nil
 }
 
-func itemToString(item model.Item) []string {
+// itemToString returns the expected columns of the item followed by the values
+// of its tags named after extraHeaders, multiple values are joined by a comma.
+func itemToString(item model.Item, extraHeaders []string) []string {
 	res := []string{}
 
-	// Convert all fields of the struct to strings using reflection
+	// Convert all fields of the struct but the tags to strings using reflection
 	v := reflect.ValueOf(item)
 	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Name == "Tags" {
+			continue
+		}
 		res = append(res, fmt.Sprintf("%v", v.Field(i).Interface()))
 	}
 
+	for _, header := range extraHeaders {
+		var values []string
+		for _, tag := range item.Tags {
+			if tag.Name == header {
+				values = append(values, tag.Value)
+			}
+		}
+		res = append(res, strings.Join(values, ","))
+	}
+
+	return res
+}
+
+// extraTagHeaders returns the sorted names of the tags not covered by a column of the default mapping.
+func extraTagHeaders(items []model.Item) []string {
+	columnTags := defaultMapping.Tags()
+	names := make(map[string]bool)
+	for _, item := range items {
+		for _, tag := range item.Tags {
+			if !columnTags[tag.Name] {
+				names[tag.Name] = true
+			}
+		}
+	}
+	res := make([]string, 0, len(names))
+	for name := range names {
+		res = append(res, name)
+	}
+	sort.Strings(res)
 	return res
 }
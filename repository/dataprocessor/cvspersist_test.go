@@ -0,0 +1,57 @@
+package dataprocessor
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+	"githb.com/Go-routine-4595/stream-ingest/model"
+)
+
+// TestExportRoundTrip exports streams with Persist and reads them back with the default mapping.
+func TestExportRoundTrip(t *testing.T) {
+	full := stream.NewStream()
+	full.SiteCode, full.SensorID, full.StreamName, full.Process, full.UOM = "S1", "T1", "Temp", "CNC", "C"
+	full.MinValue, full.MaxValue = -10.5, 120
+	full.ScaleFactor, full.Precision = 10, 2
+	full.LoLo, full.Lo, full.Hi, full.HiHi = -5, 0, 100.25, 110
+	full.Step = false
+	full.Tags = stream.NewTagSet(
+		model.Tag{Name: "SiteShortCode", Value: "S"},
+		model.Tag{Name: "Subunit", Value: "a"},
+		model.Tag{Name: "Subunit", Value: "b"},
+		model.Tag{Name: "Area", Value: "North"},
+	)
+	bare := stream.NewStream()
+	bare.SiteCode, bare.SensorID = "S1", "T2"
+
+	fileName := filepath.Join(t.TempDir(), "export.csv")
+	persist, err := NewCSVPersist(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = persist.Persist([]model.Item{full.ConvertStreamToItem(), bare.ConvertStreamToItem()}); err != nil {
+		t.Fatal(err)
+	}
+	persist.Close()
+
+	streams, err := readFile(fileName, ReaderOptions{Mapping: DefaultMapping()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 2 {
+		t.Fatalf("read %d streams, want 2", len(streams))
+	}
+	for i, want := range []stream.Stream{full, bare} {
+		for _, c := range stream.Diff(want, *streams[i]) {
+			// an empty tag column is read as a tag without value
+			if c.Kind != stream.Added || c.After != "" {
+				t.Errorf("%s read back with change %v", want.SensorID, c)
+			}
+		}
+	}
+	if got := streams[0].Tags.Values("Subunit"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Subunit = %v, want a and b", got)
+	}
+}
@@ -0,0 +1,81 @@
+package dataprocessor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Decimal separators of ReaderOptions.Decimal
+const (
+	DecimalAuto  = ""
+	DecimalDot   = "."
+	DecimalComma = ","
+)
+
+// normalizeDecimal converts a decimal number written with the decimal separator sep, or the one
+// guessed from the number when sep is DecimalAuto, to the notation of strconv.ParseFloat.
+// Thousands separators (spaces, apostrophes and the other separator) are removed.
+// When guessed, the last of '.' and ',' is the decimal separator when both are used, and a
+// separator used only once is the decimal one: "1.234,5" and "0,5" are 1234.5 and 0.5, "1,234,567" is 1234567.
+// It also returns the decimals of the number as written, "1.50" has 2, "1.5e1" has 0.
+func normalizeDecimal(value string, sep string) (string, int, error) {
+	number := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\u00a0', '\u202f', '\'', '\u2019': // thousands separators
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(value))
+
+	if sep == DecimalAuto {
+		sep = guessDecimal(number)
+	}
+	switch sep {
+	case DecimalDot:
+		number = strings.ReplaceAll(number, ",", "")
+	case DecimalComma:
+		number = strings.ReplaceAll(number, ".", "")
+		number = strings.Replace(number, ",", ".", 1)
+	default:
+		return "", 0, fmt.Errorf("unknown decimal separator %q, want %q or %q", sep, DecimalDot, DecimalComma)
+	}
+	if _, err := strconv.ParseFloat(number, 64); err != nil {
+		return "", 0, fmt.Errorf("invalid decimal %q", value)
+	}
+	return number, decimals(number), nil
+}
+
+// guessDecimal returns the decimal separator of a number written with either separator.
+func guessDecimal(number string) string {
+	dot, comma := strings.LastIndexByte(number, '.'), strings.LastIndexByte(number, ',')
+	switch {
+	case dot >= 0 && comma >= 0:
+		if comma > dot {
+			return DecimalComma
+		}
+		return DecimalDot
+	case comma >= 0 && strings.Count(number, ",") == 1:
+		return DecimalComma
+	case dot >= 0 && strings.Count(number, ".") > 1:
+		// dots as thousands separators: 1.234.567
+		return DecimalComma
+	default:
+		return DecimalDot
+	}
+}
+
+// decimals returns the digits after the decimal point of a number in the notation of
+// strconv.ParseFloat, less its exponent.
+func decimals(number string) int {
+	exponent := 0
+	if i := strings.IndexAny(number, "eE"); i >= 0 {
+		exponent, _ = strconv.Atoi(number[i+1:])
+		number = number[:i]
+	}
+	n := 0
+	if i := strings.IndexByte(number, '.'); i >= 0 {
+		n = len(number) - i - 1
+	}
+	return max(n-exponent, 0)
+}
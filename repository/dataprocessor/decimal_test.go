@@ -0,0 +1,66 @@
+package dataprocessor
+
+import "testing"
+
+func TestNormalizeDecimal(t *testing.T) {
+	tests := []struct {
+		value    string
+		sep      string
+		want     string
+		decimals int
+		wantErr  bool
+	}{
+		{"12", DecimalAuto, "12", 0, false},
+		{"-12.75", DecimalAuto, "-12.75", 2, false},
+		{"0,5", DecimalAuto, "0.5", 1, false},
+		{"1e3", DecimalAuto, "1e3", 0, false},
+		{"1.5e1", DecimalAuto, "1.5e1", 0, false},
+		{"1.25e-2", DecimalAuto, "1.25e-2", 4, false},
+		{"1.50", DecimalAuto, "1.50", 2, false},
+		{"1.234,5", DecimalAuto, "1234.5", 1, false},
+		{"1,234.5", DecimalAuto, "1234.5", 1, false},
+		{"1,234,567", DecimalAuto, "1234567", 0, false},
+		{"1.234.567", DecimalAuto, "1234567", 0, false},
+		{"1 234,5", DecimalAuto, "1234.5", 1, false},
+		{"1 234,5", DecimalAuto, "1234.5", 1, false},
+		{"1'234.5", DecimalAuto, "1234.5", 1, false},
+		{" 7 ", DecimalAuto, "7", 0, false},
+		{"1,234", DecimalDot, "1234", 0, false},
+		{"1,234", DecimalComma, "1.234", 3, false},
+		{"1.234", DecimalComma, "1234", 0, false},
+		{"abc", DecimalAuto, "", 0, true},
+		{"1,2,3.4,5", DecimalAuto, "", 0, true},
+		{"", DecimalAuto, "", 0, true},
+		{"1", ";", "", 0, true},
+	}
+	for _, tt := range tests {
+		got, decimals, err := normalizeDecimal(tt.value, tt.sep)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("normalizeDecimal(%q, %q) error = %v, want error %v", tt.value, tt.sep, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want || decimals != tt.decimals {
+			t.Errorf("normalizeDecimal(%q, %q) = %q, %d, want %q, %d", tt.value, tt.sep, got, decimals, tt.want, tt.decimals)
+		}
+	}
+}
+
+func TestGuessDecimal(t *testing.T) {
+	tests := []struct {
+		number string
+		want   string
+	}{
+		{"12", DecimalDot},
+		{"12.5", DecimalDot},
+		{"12,5", DecimalComma},
+		{"1.234,5", DecimalComma},
+		{"1,234.5", DecimalDot},
+		{"1,234,567", DecimalDot},
+		{"1.234.567", DecimalComma},
+	}
+	for _, tt := range tests {
+		if got := guessDecimal(tt.number); got != tt.want {
+			t.Errorf("guessDecimal(%q) = %q, want %q", tt.number, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package dataprocessor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// Encodings detected in a text file
+const (
+	EncodingUTF8        = "UTF-8"
+	EncodingUTF16LE     = "UTF-16LE"
+	EncodingUTF16BE     = "UTF-16BE"
+	EncodingWindows1252 = "windows-1252"
+)
+
+// sniffSize is the number of bytes looked at to detect the encoding of a file without BOM
+const sniffSize = 64 * 1024
+
+// decodeText converts the text file to UTF-8 from name, an IANA encoding name such as
+// UTF-16LE, windows-1252 or ISO-8859-1, or from the encoding detected when name is empty.
+// Bytes that are not valid in the encoding become the replacement character U+FFFD.
+// It returns the name of the encoding used.
+func decodeText(file io.ReadCloser, name string) (io.ReadCloser, string, error) {
+	buffered := bufio.NewReaderSize(file, sniffSize)
+	var enc encoding.Encoding
+	if name == "" {
+		sample, _ := buffered.Peek(sniffSize)
+		name = detectEncoding(sample)
+		enc = encodings[name]
+	} else {
+		var err error
+		if enc, err = ianaindex.IANA.Encoding(name); err != nil || enc == nil {
+			return nil, "", fmt.Errorf("unknown encoding %q, want for instance %s", name, strings.Join([]string{EncodingUTF8, EncodingUTF16LE, EncodingUTF16BE, EncodingWindows1252, "ISO-8859-1"}, ", "))
+		}
+		if canonical, err := ianaindex.MIME.Name(enc); err == nil {
+			name = canonical
+		}
+		if enc == unicode.UTF8 {
+			enc = unicode.UTF8BOM
+		}
+	}
+	return readCloser{Reader: transform.NewReader(buffered, enc.NewDecoder()), Closer: file}, name, nil
+}
+
+// encodings decodes the detected encodings, a BOM is removed
+var encodings = map[string]encoding.Encoding{
+	EncodingUTF8:        unicode.UTF8BOM,
+	EncodingUTF16LE:     unicode.UTF16(unicode.LittleEndian, unicode.UseBOM),
+	EncodingUTF16BE:     unicode.UTF16(unicode.BigEndian, unicode.UseBOM),
+	EncodingWindows1252: charmap.Windows1252,
+}
+
+// detectEncoding guesses the encoding of the beginning of a file: from its BOM, otherwise UTF-16
+// when every other byte is mostly zero, UTF-8 when the bytes are valid UTF-8 and windows-1252,
+// the encoding of Excel on Western Windows and a superset of Latin-1, otherwise.
+func detectEncoding(sample []byte) string {
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xEF, 0xBB, 0xBF}):
+		return EncodingUTF8
+	case bytes.HasPrefix(sample, []byte{0xFF, 0xFE}):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(sample, []byte{0xFE, 0xFF}):
+		return EncodingUTF16BE
+	}
+
+	var zeros [2]int
+	for i, b := range sample {
+		if b == 0 {
+			zeros[i%2]++
+		}
+	}
+	half := len(sample) / 2
+	switch {
+	case half > 0 && zeros[1] > half*3/10 && zeros[0] < half/20:
+		return EncodingUTF16LE
+	case half > 0 && zeros[0] > half*3/10 && zeros[1] < half/20:
+		return EncodingUTF16BE
+	}
+
+	// a full sample may end in the middle of a character, a shorter one is the whole file
+	trim := 0
+	if len(sample) == sniffSize {
+		trim = utf8.UTFMax - 1
+	}
+	for i := 0; i <= trim && i <= len(sample); i++ {
+		if utf8.Valid(sample[:len(sample)-i]) {
+			return EncodingUTF8
+		}
+	}
+	return EncodingWindows1252
+}
+
+// readCloser reads from Reader and closes Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// hasReplacement tells if a cell holds the replacement character, left by bytes invalid in the encoding.
+func hasReplacement(cells []string) bool {
+	for _, cell := range cells {
+		if strings.ContainsRune(cell, utf8.RuneError) {
+			return true
+		}
+	}
+	return false
+}
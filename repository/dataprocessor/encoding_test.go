@@ -0,0 +1,98 @@
+package dataprocessor
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// encode returns text encoded by the encoder of the encoding name.
+func encode(t *testing.T, name string, text string) []byte {
+	t.Helper()
+	var (
+		data []byte
+		err  error
+	)
+	switch name {
+	case EncodingUTF16LE:
+		data, err = unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder().Bytes([]byte(text))
+	case EncodingUTF16BE:
+		data, err = unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewEncoder().Bytes([]byte(text))
+	case EncodingWindows1252:
+		data, err = charmap.Windows1252.NewEncoder().Bytes([]byte(text))
+	default:
+		data = []byte(text)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDetectEncoding(t *testing.T) {
+	const text = "SiteCode,SensorID,Name\nS1,T1,Température – °C\n"
+	tests := []struct {
+		name   string
+		sample []byte
+		want   string
+	}{
+		{"utf-8", encode(t, EncodingUTF8, text), EncodingUTF8},
+		{"ascii", []byte("a,b\n1,2\n"), EncodingUTF8},
+		{"utf-8 bom", append([]byte{0xEF, 0xBB, 0xBF}, text...), EncodingUTF8},
+		{"utf-16le bom", append([]byte{0xFF, 0xFE}, encode(t, EncodingUTF16LE, text)...), EncodingUTF16LE},
+		{"utf-16be bom", append([]byte{0xFE, 0xFF}, encode(t, EncodingUTF16BE, text)...), EncodingUTF16BE},
+		{"utf-16le", encode(t, EncodingUTF16LE, text), EncodingUTF16LE},
+		{"utf-16be", encode(t, EncodingUTF16BE, text), EncodingUTF16BE},
+		{"windows-1252", encode(t, EncodingWindows1252, text), EncodingWindows1252},
+		{"utf-8 cut in a character", encode(t, EncodingUTF8, strings.Repeat("a", sniffSize-1)+"é")[:sniffSize], EncodingUTF8},
+		{"windows-1252 last byte", []byte("Name\nCaf\xe9"), EncodingWindows1252},
+		{"empty", nil, EncodingUTF8},
+	}
+	for _, tt := range tests {
+		if got := detectEncoding(tt.sample); got != tt.want {
+			t.Errorf("%s: detectEncoding = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	const text = "Name\nTempérature °C\n"
+	tests := []struct {
+		name     string
+		data     []byte
+		encoding string
+		want     string
+		wantName string
+	}{
+		{"detected windows-1252", encode(t, EncodingWindows1252, text), "", text, EncodingWindows1252},
+		{"detected utf-16le with bom", append([]byte{0xFF, 0xFE}, encode(t, EncodingUTF16LE, text)...), "", text, EncodingUTF16LE},
+		{"utf-8 bom removed", append([]byte{0xEF, 0xBB, 0xBF}, text...), "", text, EncodingUTF8},
+		{"forced latin1", encode(t, EncodingWindows1252, text), "latin1", text, "ISO-8859-1"},
+		{"forced utf-16le without bom", encode(t, EncodingUTF16LE, text), "UTF-16LE", text, EncodingUTF16LE},
+	}
+	for _, tt := range tests {
+		r, name, err := decodeText(io.NopCloser(strings.NewReader(string(tt.data))), tt.encoding)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want || name != tt.wantName {
+			t.Errorf("%s: decoded %q as %s, want %q as %s", tt.name, got, name, tt.want, tt.wantName)
+		}
+	}
+
+	if _, _, err := decodeText(io.NopCloser(strings.NewReader("")), "klingon"); err == nil {
+		t.Error("an unknown encoding is accepted")
+	}
+	if !hasReplacement([]string{"a", "b�c"}) || hasReplacement([]string{"a", "é"}) {
+		t.Error("hasReplacement is wrong")
+	}
+}
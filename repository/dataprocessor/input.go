@@ -0,0 +1,233 @@
+package dataprocessor
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Stdin is the file name of the standard input
+const Stdin = "-"
+
+var (
+	magicGzip = []byte{0x1f, 0x8b}
+	magicZstd = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicZip  = []byte("PK\x03\x04")
+)
+
+// input is an input file: a file, the standard input, a compressed file or a zip archive.
+// A zip archive holds several entries, read one after the other.
+// The bytes read from the file are counted to report the progress of the reading.
+type input struct {
+	entries []entry
+	size    int64 // bytes of the file, -1 when unknown (pipe)
+	read    *int64
+	close   func() error
+}
+
+// entry is a table of an input file, opened once.
+type entry struct {
+	name   string // file name, for an archive entry "archive.zip:entry.csv"
+	format string // from the extension of the name without the compression extension
+	open   func() (io.ReadCloser, error)
+}
+
+// openInput opens the file, or the standard input for "-". Compressed files (gzip, zstd) and
+// zip archives are recognized by their first bytes, the format of a table by its extension,
+// unless format is given. A workbook, also a zip archive, is read as a table.
+func openInput(filePath string, format string) (*input, error) {
+	var (
+		file *os.File
+		name = filePath
+		err  error
+	)
+	if filePath == Stdin {
+		file, name = os.Stdin, "stdin"
+	} else if file, err = os.Open(filePath); err != nil {
+		return nil, NewCSVReaderError("open file", err)
+	}
+	closeFile := func() error {
+		if file == os.Stdin {
+			return nil
+		}
+		return file.Close()
+	}
+
+	in := &input{size: -1, read: new(int64), close: closeFile}
+	if info, err := file.Stat(); err == nil && info.Mode().IsRegular() {
+		in.size = info.Size()
+	}
+	buffered := bufio.NewReader(countingReader{r: file, n: in.read})
+	magic, _ := buffered.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, magicGzip):
+		in.entries = []entry{{name: name, format: entryFormat(trimExt(name, ".gz", ".gzip"), format), open: func() (io.ReadCloser, error) {
+			return gzip.NewReader(buffered)
+		}}}
+	case bytes.HasPrefix(magic, magicZstd):
+		in.entries = []entry{{name: name, format: entryFormat(trimExt(name, ".zst", ".zstd"), format), open: func() (io.ReadCloser, error) {
+			decoder, err := zstd.NewReader(buffered)
+			if err != nil {
+				return nil, err
+			}
+			return decoder.IOReadCloser(), nil
+		}}}
+	case bytes.HasPrefix(magic, magicZip) && entryFormat(name, format) != FormatXLSX:
+		// a workbook is a zip archive too
+		if in.entries, err = zipEntries(name, in, file, buffered, format); err != nil {
+			closeFile()
+			return nil, NewCSVReaderError("failed to read archive "+name, err)
+		}
+	default:
+		in.entries = []entry{{name: name, format: entryFormat(name, format), open: func() (io.ReadCloser, error) {
+			return io.NopCloser(buffered), nil
+		}}}
+	}
+	return in, nil
+}
+
+// zipEntries returns the tables of the archive: the entries with the extension of a known
+// format, every file when format is given. An archive read from stdin is held in memory.
+func zipEntries(name string, in *input, file *os.File, buffered *bufio.Reader, format string) ([]entry, error) {
+	var (
+		archive *zip.Reader
+		err     error
+	)
+	if file != os.Stdin && in.size >= 0 {
+		archive, err = zip.NewReader(countingReaderAt{r: file, n: in.read}, in.size)
+	} else {
+		var data []byte
+		if data, err = io.ReadAll(buffered); err == nil {
+			archive, err = zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []entry
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if format == "" && !knownExt(f.Name) {
+			continue
+		}
+		entries = append(entries, entry{
+			name:   name + ":" + f.Name,
+			format: entryFormat(f.Name, format),
+			open:   f.Open,
+		})
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no CSV, JSON or workbook file in the archive")
+	}
+	return entries, nil
+}
+
+// Close closes the input file.
+func (in *input) Close() error {
+	return in.close()
+}
+
+// offset returns the bytes read from the file, at most its size.
+func (in *input) offset() int64 {
+	if in.size >= 0 && *in.read > in.size {
+		return in.size
+	}
+	return *in.read
+}
+
+// openSource opens the table of the entry in its format, the sheet of a workbook and a text file
+// decoded from its encoding, detected when not given. It returns the encoding of a text file.
+func (e entry) openSource(sheet string, encoding string) (RowSource, string, error) {
+	if sheet != "" && e.format != FormatXLSX {
+		return nil, "", NewCSVReaderError("open file", errSheetOnCSV)
+	}
+	if encoding != "" && e.format == FormatXLSX {
+		return nil, "", NewCSVReaderError("open file", errEncodingOnXLSX)
+	}
+	if !contains(Formats(), e.format) {
+		return nil, "", NewCSVReaderError("open file", fmt.Errorf("unknown format %q, want one of %s", e.format, strings.Join(Formats(), ", ")))
+	}
+	r, err := e.open()
+	if err != nil {
+		return nil, "", NewCSVReaderError("open "+e.name, err)
+	}
+	if e.format == FormatXLSX {
+		source, err := newXLSXSource(e.name, r, sheet)
+		return source, "", err
+	}
+
+	r, encoding, err = decodeText(r, encoding)
+	if err != nil {
+		return nil, "", NewCSVReaderError("open "+e.name, err)
+	}
+	switch e.format {
+	case FormatJSON:
+		source, err := newJSONSource(e.name, r, false)
+		return source, encoding, err
+	case FormatJSONL:
+		source, err := newJSONSource(e.name, r, true)
+		return source, encoding, err
+	default:
+		return newCSVSource(e.name, r), encoding, nil
+	}
+}
+
+// entryFormat returns format when given, otherwise the format of the extension of name.
+func entryFormat(name string, format string) string {
+	if format != "" {
+		return strings.ToLower(format)
+	}
+	return fileFormat(name)
+}
+
+// knownExt tells if the extension of the name is the one of a format.
+func knownExt(name string) bool {
+	return fileFormat(name) != FormatCSV || strings.EqualFold(path.Ext(name), ".csv")
+}
+
+// trimExt removes the first of the extensions name ends with.
+func trimExt(name string, exts ...string) string {
+	ext := filepath.Ext(name)
+	for _, e := range exts {
+		if strings.EqualFold(ext, e) {
+			return strings.TrimSuffix(name, ext)
+		}
+	}
+	return name
+}
+
+// countingReader counts the bytes read from r in n.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+// countingReaderAt counts the bytes read from r in n.
+type countingReaderAt struct {
+	r io.ReaderAt
+	n *int64
+}
+
+func (c countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	*c.n += int64(n)
+	return n, err
+}
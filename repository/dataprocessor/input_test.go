@@ -0,0 +1,224 @@
+package dataprocessor
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const inputCSV = "SensorID,SiteCode\nT1,S1\nT2,S1\n"
+
+func gzipData(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func zstdData(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+// zipData returns an archive of the files, by name.
+func zipData(t *testing.T, names []string, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range names {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestCompressedInput(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		data    []byte
+		format  string
+		want    []string // sensor IDs read
+		wantErr string
+	}{
+		{"gzip", "in.csv.gz", gzipData(t, inputCSV), "", []string{"T1", "T2"}, ""},
+		{"gzip without extension", "in", gzipData(t, inputCSV), "", []string{"T1", "T2"}, ""},
+		{"gzip jsonl", "in.jsonl.gz", gzipData(t, `{"SensorID": "T1", "SiteCode": "S1"}`), "", []string{"T1"}, ""},
+		{"zstd", "in.csv.zst", zstdData(t, inputCSV), "", []string{"T1", "T2"}, ""},
+		{"zip", "in.zip", zipData(t, []string{"a.csv", "notes.txt", "b.jsonl"}, map[string]string{
+			"a.csv":     inputCSV,
+			"notes.txt": "not a table",
+			"b.jsonl":   `{"SensorID": "T3", "SiteCode": "S1"}`,
+		}), "", []string{"T1", "T2", "T3"}, ""},
+		{"zip with format", "in.zip", zipData(t, []string{"a.txt", "b.txt"}, map[string]string{
+			"a.txt": inputCSV,
+			"b.txt": "SiteCode,SensorID\nS1,T3\n",
+		}), "csv", []string{"T1", "T2", "T3"}, ""},
+		{"zip without table", "in.zip", zipData(t, []string{"notes.txt"}, map[string]string{"notes.txt": "x"}), "", nil, "no CSV, JSON or workbook file"},
+	}
+	for _, tt := range tests {
+		fileName := filepath.Join(t.TempDir(), tt.file)
+		if err := os.WriteFile(fileName, tt.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		streams, err := readFile(fileName, ReaderOptions{Format: tt.format})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, s := range streams {
+			got = append(got, s.SensorID)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: read %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestZipRows(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "in.zip")
+	data := zipData(t, []string{"a.csv", "b.csv"}, map[string]string{"a.csv": inputCSV, "b.csv": inputCSV})
+	if err := os.WriteFile(fileName, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(fileName, "tester", ReaderOptions{Mapping: testMapping})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	// the rows of the entries are numbered one after the other, each entry has its header
+	var rows []int
+	for {
+		if _, err = r.ReadNext(); err != nil {
+			break
+		}
+		rows = append(rows, r.Row())
+	}
+	if !reflect.DeepEqual(rows, []int{2, 3, 5, 6}) {
+		t.Errorf("rows = %v, want 2 3 5 6", rows)
+	}
+	if r.Offset() != r.Size() || r.Size() != int64(len(data)) {
+		t.Errorf("read %d of %d bytes, want %d", r.Offset(), r.Size(), len(data))
+	}
+}
+
+func TestStdinInput(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		format string
+	}{
+		{"csv", []byte(inputCSV), ""},
+		{"gzip", gzipData(t, inputCSV), ""},
+		{"zip", zipData(t, []string{"a.csv"}, map[string]string{"a.csv": inputCSV}), ""},
+		{"json", []byte(`[{"SensorID": "T1", "SiteCode": "S1"}, {"SensorID": "T2", "SiteCode": "S1"}]`), FormatJSON},
+	}
+	for _, tt := range tests {
+		fileName := filepath.Join(t.TempDir(), "stdin")
+		if err := os.WriteFile(fileName, tt.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdin := os.Stdin
+		os.Stdin = f
+		streams, err := readFile(Stdin, ReaderOptions{Format: tt.format})
+		os.Stdin = stdin
+		f.Close()
+		if err != nil || len(streams) != 2 || streams[1].SensorID != "T2" {
+			t.Errorf("%s: read %d streams, %v, want T1 and T2", tt.name, len(streams), err)
+		}
+	}
+}
+
+// readRows reads the file to the end, or to 2000 calls, and returns the sensor IDs read
+// and the number of errors.
+func readRows(t *testing.T, fileName string) ([]string, int) {
+	t.Helper()
+	r, err := NewReader(fileName, "tester", ReaderOptions{Mapping: testMapping})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var read []string
+	errs := 0
+	for i := 0; i < 2000; i++ {
+		s, err := r.ReadNext()
+		if errors.Is(err, io.EOF) {
+			return read, errs
+		}
+		if err != nil {
+			errs++
+			continue
+		}
+		read = append(read, s.SensorID)
+	}
+	t.Fatalf("no io.EOF after %d rows and %d errors", len(read), errs)
+	return nil, 0
+}
+
+func TestTruncatedInput(t *testing.T) {
+	var data strings.Builder
+	data.WriteString("SensorID,SiteCode\n")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&data, "T%d,S1\n", i)
+	}
+	compressed := gzipData(t, data.String())
+	fileName := filepath.Join(t.TempDir(), "in.csv.gz")
+	if err := os.WriteFile(fileName, compressed[:len(compressed)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// the rows before the truncation are read, then the error once and io.EOF
+	if read, errs := readRows(t, fileName); errs != 1 || len(read) == 0 || len(read) == 1000 {
+		t.Errorf("read %d rows with %d errors, want some rows and one error", len(read), errs)
+	}
+}
+
+func TestRowErrorContinues(t *testing.T) {
+	// a CSV syntax error is the error of its row, the next rows are read
+	fileName := filepath.Join(t.TempDir(), "in.csv")
+	if err := os.WriteFile(fileName, []byte("SensorID,SiteCode\nT1,S1\n\"T2\"x,S1\nT3,S1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if read, errs := readRows(t, fileName); errs != 1 || !reflect.DeepEqual(read, []string{"T1", "T3"}) {
+		t.Errorf("read %v with %d errors, want T1 and T3 and one error", read, errs)
+	}
+}
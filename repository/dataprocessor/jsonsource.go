@@ -0,0 +1,269 @@
+package dataprocessor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"githb.com/Go-routine-4595/stream-ingest/model"
+)
+
+// jsonSource reads the objects of a JSON array (.json) or of a JSON Lines file (.jsonl) as rows.
+// Its header, returned as row 0, lists every key found in the objects in order of appearance,
+// so the objects are matched by the column mapping like the rows of a CSV file.
+// The "tags" key of an object holds free-form tags, as a list of {"name", "value"} objects
+// or as an object of name: value(s).
+type jsonSource struct {
+	name    string
+	lines   bool // JSON Lines: rows are numbered by line, otherwise by object
+	header  []string
+	records []jsonRecord
+	next    int // index of the next record to read, -1 before the header
+	tags    []model.Tag
+}
+
+// jsonRecord is an object of the file
+type jsonRecord struct {
+	row   int
+	cells map[string]string
+	tags  []model.Tag
+	err   error // the object cannot be read
+}
+
+// tagSource is implemented by the sources whose rows hold tags besides their cells.
+type tagSource interface {
+	// rowTags returns the tags of the last row read.
+	rowTags() []model.Tag
+}
+
+// tagsKey is the key of the free-form tags of an object, compared normalized
+const tagsKey = "tags"
+
+// newJSONSource reads every object of the file.
+func newJSONSource(name string, file io.ReadCloser, lines bool) (*jsonSource, error) {
+	defer file.Close()
+
+	var err error
+	s := &jsonSource{name: name, lines: lines, next: -1}
+	if lines {
+		err = s.readLines(file)
+	} else {
+		err = s.readArray(file)
+	}
+	if err != nil {
+		return nil, NewCSVReaderError("failed to read "+s.name, err)
+	}
+	return s, nil
+}
+
+// readArray reads a JSON array of objects, a malformed file cannot be read at all.
+func (s *jsonSource) readArray(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return errors.New("expected an array of objects")
+	}
+	for row := 1; dec.More(); row++ {
+		keys, values, err := decodeObject(dec)
+		if err != nil {
+			return fmt.Errorf("object %d: %w", row, err)
+		}
+		s.add(row, keys, values)
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// readLines reads one object per line, a malformed line is reported when its row is read.
+func (s *jsonSource) readLines(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for row := 1; scanner.Scan(); row++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		keys, values, err := decodeObject(dec)
+		if err != nil {
+			s.records = append(s.records, jsonRecord{row: row, err: err})
+			continue
+		}
+		s.add(row, keys, values)
+	}
+	return scanner.Err()
+}
+
+// add records the object read at row and adds its new keys to the header.
+func (s *jsonSource) add(row int, keys []string, values []json.RawMessage) {
+	rec := jsonRecord{row: row, cells: make(map[string]string, len(keys))}
+	for i, key := range keys {
+		if NormalizeHeader(key) == tagsKey {
+			tags, err := decodeTags(values[i])
+			if err != nil {
+				rec.err = fmt.Errorf("invalid %s: %w", key, err)
+			}
+			rec.tags = tags
+			continue
+		}
+		if !contains(s.header, key) {
+			s.header = append(s.header, key)
+		}
+		rec.cells[key] = cellValue(values[i])
+	}
+	s.records = append(s.records, rec)
+}
+
+func (s *jsonSource) Read() ([]string, int, error) {
+	if len(s.records) == 0 {
+		return nil, 0, io.EOF
+	}
+	if s.next < 0 {
+		s.next = 0
+		return append([]string{}, s.header...), 0, nil
+	}
+	if s.next >= len(s.records) {
+		return nil, 0, io.EOF
+	}
+	rec := s.records[s.next]
+	s.next++
+	s.tags = rec.tags
+	if rec.err != nil {
+		return nil, rec.row, &rowError{err: rec.err}
+	}
+	cells := make([]string, len(s.header))
+	for i, key := range s.header {
+		cells[i] = rec.cells[key]
+	}
+	return cells, rec.row, nil
+}
+
+func (s *jsonSource) rowTags() []model.Tag {
+	return s.tags
+}
+
+func (s *jsonSource) Position(row int, col int) string {
+	unit := "object"
+	if s.lines {
+		unit = "line"
+	}
+	if col < 0 || col >= len(s.header) {
+		return fmt.Sprintf("%s %d", unit, row)
+	}
+	return fmt.Sprintf("%s %d, field %s", unit, row, s.header[col])
+}
+
+func (s *jsonSource) Name() string {
+	return s.name
+}
+
+func (s *jsonSource) Close() error {
+	return nil
+}
+
+// decodeObject reads the next object of dec and returns its keys, in order, and their raw values.
+func decodeObject(dec *json.Decoder) ([]string, []json.RawMessage, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, nil, errors.New("expected an object")
+	}
+	var (
+		keys   []string
+		values []json.RawMessage
+	)
+	for dec.More() {
+		tok, err = dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, tok.(string))
+		values = append(values, value)
+	}
+	if _, err = dec.Token(); err != nil {
+		return nil, nil, err
+	}
+	return keys, values, nil
+}
+
+// decodeTags reads free-form tags given as [{"name": n, "value": v}] or as {n: v} where v may be a list.
+func decodeTags(data json.RawMessage) ([]model.Tag, error) {
+	var tags []model.Tag
+	var list []struct {
+		Name  string          `json:"name"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &list); err == nil {
+		for _, t := range list {
+			if t.Name == "" {
+				return nil, errors.New("a tag has no name")
+			}
+			tags = append(tags, model.Tag{Name: t.Name, Value: cellValue(t.Value)})
+		}
+		return tags, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	keys, values, err := decodeObject(dec)
+	if err != nil {
+		return nil, errors.New("expected a list of name/value objects or an object")
+	}
+	for i, name := range keys {
+		var many []json.RawMessage
+		if err = json.Unmarshal(values[i], &many); err == nil {
+			for _, v := range many {
+				tags = append(tags, model.Tag{Name: name, Value: cellValue(v)})
+			}
+			continue
+		}
+		tags = append(tags, model.Tag{Name: name, Value: cellValue(values[i])})
+	}
+	return tags, nil
+}
+
+// cellValue returns the text of a JSON value: strings unquoted, numbers as written,
+// null as an empty cell and the elements of a list joined by a comma.
+func cellValue(data json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return s
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err == nil {
+		values := make([]string, len(list))
+		for i, v := range list {
+			values[i] = cellValue(v)
+		}
+		return strings.Join(values, ",")
+	}
+	text := strings.TrimSpace(string(data))
+	if text == "null" {
+		return ""
+	}
+	return text
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,286 @@
+package dataprocessor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Mapping declares the columns of an input file and how each one fills a stream,
+// so files with different layouts can be ingested without code changes.
+type Mapping struct {
+	Columns []Column `json:"columns" yaml:"columns"`
+	Extra   Extra    `json:"extra,omitempty" yaml:"extra,omitempty"`
+}
+
+// Extra selects the extra columns, the ones matching no column of the mapping, ingested as
+// tags named after their header. Every extra column is a tag unless Allow or Deny says otherwise.
+// Allow and Deny hold headers, compared normalized, where * matches any text.
+type Extra struct {
+	Allow []string `json:"allow,omitempty" yaml:"allow,omitempty"` // only the extra columns matching one of these are tags
+	Deny  []string `json:"deny,omitempty" yaml:"deny,omitempty"`   // the extra columns matching one of these are ignored
+	Split string   `json:"split,omitempty" yaml:"split,omitempty"` // separator of the values of a tag, "," when empty, none to keep them whole
+}
+
+// noSplit keeps the values of extra columns whole
+const noSplit = "none"
+
+// defaultExtraSplit is the separator of the values of extra columns
+const defaultExtraSplit = ","
+
+// Column is a column of the input file. It fills either the Stream field named Field
+// (JSON name, e.g. "sensorId") or the tag named Tag; a column with neither is read but ignored.
+type Column struct {
+	Name     string   `json:"name" yaml:"name"`                             // header of the column
+	Aliases  []string `json:"aliases,omitempty" yaml:"aliases,omitempty"`   // other headers accepted for the column
+	Field    string   `json:"field,omitempty" yaml:"field,omitempty"`       // Stream field filled by the column
+	Tag      string   `json:"tag,omitempty" yaml:"tag,omitempty"`           // tag filled by the column
+	Required bool     `json:"required,omitempty" yaml:"required,omitempty"` // the header must have the column
+	Split    string   `json:"split,omitempty" yaml:"split,omitempty"`       // separator of the values of a multi-value tag
+	Default  string   `json:"default,omitempty" yaml:"default,omitempty"`   // value used when the cell is empty, not by an update of a field
+}
+
+// defaultMapping is the layout of the registry spreadsheet the tool was first written for.
+var defaultMapping = Mapping{Columns: []Column{
+	{Name: "SiteCode", Field: "siteCode", Required: true},
+	{Name: "SensorID", Field: "sensorId", Required: true},
+	{Name: "Name", Aliases: []string{"StreamName"}, Field: "streamName", Required: true},
+	{Name: "Process", Field: "process", Required: true},
+	{Name: "MinValue", Field: "minValue", Required: true, Default: "0"},
+	{Name: "MaxValue", Field: "maxValue", Required: true, Default: "0"},
+	{Name: "Uom", Aliases: []string{"UOM"}, Field: "uom", Required: true},
+	{Name: "SiteShortCode", Tag: "SiteShortCode", Required: true},
+	{Name: "System", Tag: "System", Required: true},
+	{Name: "EquipmentUnit", Required: true},
+	{Name: "Subunit", Tag: "Subunit", Required: true, Split: ","},
+	{Name: "EquipmentComponent", Tag: "EquipmentComponent", Required: true, Split: ","},
+	{Name: "EquipmentMeasurement", Tag: "EquipmentMeasurement", Required: true},
+	{Name: "UDE", Tag: "UDE", Required: true, Split: ","},
+	{Name: "SAP Equipment ID", Aliases: []string{"SAPEquipmentID"}, Tag: "SAP Equipment ID", Required: true},
+	{Name: "ScaleFactor", Field: "scaleFactor"},
+	{Name: "Precision", Field: "precision"},
+	{Name: "LoLo", Field: "loLo"},
+	{Name: "Lo", Field: "lo"},
+	{Name: "Hi", Field: "hi"},
+	{Name: "HiHi", Field: "hiHi"},
+	{Name: "Step", Field: "step"},
+}}
+
+// DefaultMapping returns the mapping used when no mapping file is given.
+func DefaultMapping() Mapping {
+	columns := make([]Column, len(defaultMapping.Columns))
+	copy(columns, defaultMapping.Columns)
+	return Mapping{Columns: columns, Extra: defaultMapping.Extra}
+}
+
+// LoadMapping reads a mapping file, JSON when its extension is .json and YAML otherwise,
+// and validates it.
+func LoadMapping(fileName string) (Mapping, error) {
+	var m Mapping
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return m, errors.Join(fmt.Errorf("failed to read mapping %s", fileName), err)
+	}
+	if strings.EqualFold(filepath.Ext(fileName), ".json") {
+		err = json.Unmarshal(data, &m)
+	} else {
+		err = yaml.Unmarshal(data, &m)
+	}
+	if err != nil {
+		return m, errors.Join(fmt.Errorf("failed to parse mapping %s", fileName), err)
+	}
+	if err = m.Validate(); err != nil {
+		return m, errors.Join(fmt.Errorf("invalid mapping %s", fileName), err)
+	}
+	return m, nil
+}
+
+// Validate checks that every column has a name, a known target and a header not used by another column,
+// and that the keys of a stream, siteCode and sensorId, are mapped.
+func (m Mapping) Validate() error {
+	var errs []error
+
+	if len(m.Columns) == 0 {
+		errs = append(errs, errors.New("no column declared"))
+	}
+	headers := make(map[string]string)
+	for i, c := range m.Columns {
+		if c.Name == "" {
+			errs = append(errs, fmt.Errorf("column %d: missing name", i+1))
+			continue
+		}
+		for _, h := range append([]string{c.Name}, c.Aliases...) {
+			if other, ok := headers[NormalizeHeader(h)]; ok && other != c.Name {
+				errs = append(errs, fmt.Errorf("column %s: header %q is already used by column %s", c.Name, h, other))
+				continue
+			}
+			headers[NormalizeHeader(h)] = c.Name
+		}
+		switch {
+		case c.Field != "" && c.Tag != "":
+			errs = append(errs, fmt.Errorf("column %s: a column fills either a field or a tag", c.Name))
+		case c.Field != "" && !stream.IsField(c.Field):
+			errs = append(errs, fmt.Errorf("column %s: unknown field %s, expected one of %s", c.Name, c.Field, strings.Join(stream.Fields(), ", ")))
+		case c.Split != "" && c.Tag == "":
+			errs = append(errs, fmt.Errorf("column %s: split only applies to a tag", c.Name))
+		}
+	}
+	if len(m.Columns) > 0 {
+		for _, key := range []string{"siteCode", "sensorId"} {
+			if !m.mapsField(key) {
+				errs = append(errs, fmt.Errorf("no column for the field %s", key))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// mapsField tells if a column fills the field.
+func (m Mapping) mapsField(field string) bool {
+	for _, c := range m.Columns {
+		if c.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTag tells if the extra column header is ingested as a tag: it matches Allow, when given, and not Deny.
+func (e Extra) IsTag(header string) bool {
+	header = NormalizeHeader(header)
+	if header == "" {
+		return false
+	}
+	if len(e.Allow) > 0 && !matchAny(e.Allow, header) {
+		return false
+	}
+	return !matchAny(e.Deny, header)
+}
+
+// split returns the separator of the values of the extra columns, empty to keep them whole.
+func (e Extra) split() string {
+	switch e.Split {
+	case "":
+		return defaultExtraSplit
+	case noSplit:
+		return ""
+	default:
+		return e.Split
+	}
+}
+
+// matchAny tells if the normalized header matches one of the patterns.
+func matchAny(patterns []string, header string) bool {
+	for _, pattern := range patterns {
+		parts := strings.Split(NormalizeHeader(pattern), "*")
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		if regexp.MustCompile("^" + strings.Join(parts, ".*") + "$").MatchString(header) {
+			return true
+		}
+	}
+	return false
+}
+
+// Names returns the header of every column, in declaration order.
+func (m Mapping) Names() []string {
+	names := make([]string, len(m.Columns))
+	for i, c := range m.Columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// Tags returns the names of the tags filled by a column.
+func (m Mapping) Tags() map[string]bool {
+	tags := make(map[string]bool)
+	for _, c := range m.Columns {
+		if c.Tag != "" {
+			tags[c.Tag] = true
+		}
+	}
+	return tags
+}
+
+// match returns the index of the column having header as name or alias, compared normalized.
+func (m Mapping) match(header string) (int, bool) {
+	header = NormalizeHeader(header)
+	for i, c := range m.Columns {
+		if NormalizeHeader(c.Name) == header {
+			return i, true
+		}
+		for _, alias := range c.Aliases {
+			if NormalizeHeader(alias) == header {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// suggest returns the header among candidates closest to the name or an alias of column c,
+// if one is close enough to be a misspelling of it.
+func (m Mapping) suggest(c int, candidates []string) (string, bool) {
+	var (
+		best     string
+		bestDist = -1
+	)
+	for _, name := range append([]string{m.Columns[c].Name}, m.Columns[c].Aliases...) {
+		want := NormalizeHeader(name)
+		for _, candidate := range candidates {
+			got := NormalizeHeader(candidate)
+			if got == "" {
+				continue
+			}
+			d := levenshtein(want, got)
+			if d <= max(2, len(want)/3) && (bestDist < 0 || d < bestDist) {
+				best, bestDist = candidate, d
+			}
+		}
+	}
+	return best, bestDist >= 0
+}
+
+// NormalizeHeader returns the header in lower case without whitespace nor underscore,
+// so "Sensor ID", "sensor_id" and "SensorID" are the same header.
+func NormalizeHeader(header string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '_' {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, header)
+}
+
+// levenshtein returns the number of single character edits needed to change a into b.
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,134 @@
+package dataprocessor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeHeader(t *testing.T) {
+	for _, header := range []string{"SensorID", "Sensor ID", "sensor_id", " SENSOR\tID "} {
+		if got := NormalizeHeader(header); got != "sensorid" {
+			t.Errorf("NormalizeHeader(%q) = %q, want sensorid", header, got)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	m := DefaultMapping()
+	tests := []struct {
+		header string
+		want   string // name of the column, empty when none
+	}{
+		{"SiteCode", "SiteCode"},
+		{"site_code", "SiteCode"},
+		{"StreamName", "Name"},
+		{"UOM", "Uom"},
+		{"SAPEquipmentID", "SAP Equipment ID"},
+		{"lo", "Lo"},
+		{"LoLo", "LoLo"},
+		{"Comment", ""},
+	}
+	for _, tt := range tests {
+		c, ok := m.match(tt.header)
+		got := ""
+		if ok {
+			got = m.Columns[c].Name
+		}
+		if got != tt.want {
+			t.Errorf("match(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	m := DefaultMapping()
+	column := func(name string) int {
+		for i, c := range m.Columns {
+			if c.Name == name {
+				return i
+			}
+		}
+		t.Fatalf("no column %s", name)
+		return -1
+	}
+	tests := []struct {
+		column     string
+		candidates []string
+		want       string
+	}{
+		{"SensorID", []string{"Comment", "SensorIDs"}, "SensorIDs"},
+		{"SensorID", []string{"Sensr ID"}, "Sensr ID"},
+		{"Name", []string{"StreamNme", "Nam"}, "Nam"},
+		{"EquipmentMeasurement", []string{"Equipment Measurment"}, "Equipment Measurment"},
+		{"SiteCode", []string{"Comment", "Notes"}, ""},
+		{"SiteCode", nil, ""},
+	}
+	for _, tt := range tests {
+		got, ok := m.suggest(column(tt.column), tt.candidates)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("suggest(%s, %v) = %q, %v, want %q", tt.column, tt.candidates, got, ok, tt.want)
+		}
+	}
+}
+
+func TestExtraIsTag(t *testing.T) {
+	tests := []struct {
+		extra  Extra
+		header string
+		want   bool
+	}{
+		{Extra{}, "Area", true},
+		{Extra{}, " ", false},
+		{Extra{Deny: []string{"Comment*"}}, "Comments 2", false},
+		{Extra{Deny: []string{"comment*"}}, "Area", true},
+		{Extra{Allow: []string{"Area", "Zone*"}}, "zone_north", true},
+		{Extra{Allow: []string{"Area"}}, "Notes", false},
+		{Extra{Allow: []string{"*"}, Deny: []string{"Notes"}}, "notes", false},
+		{Extra{Deny: []string{"a.b"}}, "axb", true}, // not a regexp
+	}
+	for _, tt := range tests {
+		if got := tt.extra.IsTag(tt.header); got != tt.want {
+			t.Errorf("%+v IsTag(%q) = %v, want %v", tt.extra, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestLoadMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		data    string
+		wantErr string
+	}{
+		{"yaml", "m.yaml", "columns:\n  - name: ID\n    field: sensorId\n    required: true\n  - name: Site\n    field: siteCode\n  - name: Area\n    tag: Area\n    split: \",\"\n", ""},
+		{"json", "m.json", `{"columns": [{"name": "ID", "field": "sensorId"}, {"name": "Site", "field": "siteCode"}]}`, ""},
+		{"no site code", "m.yaml", "columns:\n  - name: ID\n    field: sensorId\n", "no column for the field siteCode"},
+		{"no sensor ID", "m.yaml", "columns:\n  - name: Site\n    field: siteCode\n  - name: ID\n    tag: sensorId\n", "no column for the field sensorId"},
+		{"no column", "m.yaml", "columns: []\n", "no column declared"},
+		{"unknown field", "m.yaml", "columns:\n  - name: ID\n    field: serial\n", "unknown field serial"},
+		{"field and tag", "m.yaml", "columns:\n  - name: ID\n    field: sensorId\n    tag: ID\n", "either a field or a tag"},
+		{"duplicate header", "m.yaml", "columns:\n  - name: Sensor ID\n    field: sensorId\n  - name: sensor_id\n    field: streamName\n", "already used"},
+		{"split on a field", "m.yaml", "columns:\n  - name: ID\n    field: sensorId\n    split: \",\"\n", "split only applies to a tag"},
+	}
+	for _, tt := range tests {
+		fileName := filepath.Join(t.TempDir(), tt.file)
+		if err := os.WriteFile(fileName, []byte(tt.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadMapping(fileName)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+	if _, err := LoadMapping("../../mapping.example.yaml"); err != nil {
+		t.Errorf("example mapping: %v", err)
+	}
+	if err := DefaultMapping().Validate(); err != nil {
+		t.Errorf("default mapping: %v", err)
+	}
+}
@@ -0,0 +1,465 @@
+package dataprocessor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+
+	"githb.com/Go-routine-4595/stream-ingest/model"
+)
+
+// maxHeaderRow is the last row where the header is looked for, rows above it are titles or notes.
+const maxHeaderRow = 20
+
+// ReaderOptions selects how an input file is read.
+type ReaderOptions struct {
+	Mapping  Mapping // columns of the file, DefaultMapping when empty
+	Sheet    string  // sheet of a workbook, by name or 1 based index, the first sheet when empty
+	Format   string  // format of the file (csv, xlsx, json or jsonl), from its extension when empty
+	Encoding string  // encoding of a text file, such as UTF-16LE or windows-1252, detected when empty
+	Decimal  string  // decimal separator of the numbers, "." or ",", guessed from each number when empty
+}
+
+// Reader reads the streams of an input file, a CSV file, an Excel workbook or JSON objects,
+// following a column mapping. The file may be the standard input, compressed or a zip archive,
+// whose tables are read one after the other, each with its own header row.
+type Reader struct {
+	input     *input
+	entry     int // entry of the input being read
+	sheet     string
+	encoding  string    // given encoding, detected when empty
+	decimal   string    // given decimal separator, guessed when empty
+	source    RowSource // table of the entry, nil once read
+	decodedAs string    // encoding of the text table being read
+	user      string
+	mapping   Mapping
+	headers   []string
+	headerRow int   // row of the source holding the headers
+	columns   []int // index in the row of each column of the mapping, -1 when the file does not have it
+	extra     []int // index in the row of the extra columns ingested as tags
+	row       int   // row of the source of the last stream read
+	offset    int   // rows of the entries read before the current one
+	replaced  []int // rows holding replacement characters
+	failed    bool  // a source error ended the reading
+	extraTags []string
+	ignored   []string
+}
+
+// NewReader opens the file, "-" for the standard input, and finds its header row.
+func NewReader(filePath string, user string, options ReaderOptions) (*Reader, error) {
+	in, err := openInput(filePath, options.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := options.Mapping
+	if len(mapping.Columns) == 0 {
+		mapping = DefaultMapping()
+	}
+	r := &Reader{
+		input:    in,
+		sheet:    options.Sheet,
+		encoding: options.Encoding,
+		decimal:  options.Decimal,
+		mapping:  mapping,
+		user:     user,
+	}
+
+	// Verify headers during initialization
+	err = r.openEntry(0)
+	if err != nil {
+		in.Close() // Close the file if header validation fails
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// openEntry opens the table of the entry i of the input and finds its header row.
+func (r *Reader) openEntry(i int) error {
+	r.entry = i
+	source, decodedAs, err := r.input.entries[i].openSource(r.sheet, r.encoding)
+	if err != nil {
+		return err
+	}
+	r.source, r.decodedAs = source, decodedAs
+	if err = r.validateHeaders(); err != nil {
+		r.closeSource()
+		return err
+	}
+	return nil
+}
+
+// closeSource closes the table being read, its rows are numbered before the ones of the next entry.
+func (r *Reader) closeSource() {
+	if r.source == nil {
+		return
+	}
+	r.source.Close()
+	r.source = nil
+	r.offset += r.row
+	r.row = 0
+}
+
+// position describes the cell col of row of the table being read, with the entry of an archive.
+func (r *Reader) position(row int, col int) string {
+	if len(r.input.entries) > 1 {
+		return r.input.entries[r.entry].name + ", " + r.source.Position(row, col)
+	}
+	return r.source.Position(row, col)
+}
+
+// sourceName describes the table being read, with the entry of the input when it is a sheet.
+func (r *Reader) sourceName() string {
+	name := r.input.entries[r.entry].name
+	if r.source.Name() != name {
+		return name + " " + r.source.Name()
+	}
+	return name
+}
+
+// validateHeaders finds the header row: the first row, among the first maxHeaderRow ones,
+// where every required column of the mapping is found. Headers are matched by name or alias,
+// whatever their position, case, whitespace and underscores.
+// When no row has every required column, the missing ones of the closest row are reported
+// with the unknown header that looks like each of them, if any.
+func (r *Reader) validateHeaders() error {
+	var (
+		best    []string
+		bestRow int
+		bestCnt = -1
+	)
+	for first := true; ; first = false {
+		headers, row, err := r.source.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return NewCSVReaderError("failed to read headers", err)
+		}
+		r.row = row
+		if first && len(headers) > 0 {
+			// Remove BOM
+			headers[0] = strings.TrimPrefix(headers[0], "\uFEFF")
+		}
+		columns, _, err := r.matchHeaders(headers)
+		if err != nil {
+			return NewCSVReaderError("duplicate header", fmt.Errorf("%s: %w", r.position(row, -1), err))
+		}
+		found := 0
+		for _, i := range columns {
+			if i >= 0 {
+				found++
+			}
+		}
+		if found > bestCnt {
+			best, bestRow, bestCnt = headers, row, found
+		}
+		if found > 0 && len(r.missingColumns(columns)) == 0 {
+			r.headers, r.headerRow, r.columns = headers, row, columns
+			r.row = row
+			r.findExtra()
+			return nil
+		}
+		if row >= maxHeaderRow {
+			break
+		}
+	}
+	if best == nil {
+		return NewCSVReaderError("failed to read headers", fmt.Errorf("%s is empty", r.sourceName()))
+	}
+
+	columns, unknown, _ := r.matchHeaders(best)
+	var missing []error
+	for _, c := range r.missingColumns(columns) {
+		if suggestion, ok := r.mapping.suggest(c, unknown); ok {
+			missing = append(missing, fmt.Errorf("want '%s', did you mean '%s'?", r.mapping.Columns[c].Name, suggestion))
+		} else {
+			missing = append(missing, fmt.Errorf("want '%s'", r.mapping.Columns[c].Name))
+		}
+	}
+	return NewCSVReaderError("missing header", fmt.Errorf("%s: %w", r.position(bestRow, -1), errors.Join(missing...)))
+}
+
+// matchHeaders returns the index in headers of each column of the mapping, -1 when missing,
+// and the headers matching no column.
+func (r *Reader) matchHeaders(headers []string) ([]int, []string, error) {
+	columns := make([]int, len(r.mapping.Columns))
+	for i := range columns {
+		columns[i] = -1
+	}
+	var unknown []string
+	for i, header := range headers {
+		c, ok := r.mapping.match(header)
+		if !ok {
+			unknown = append(unknown, header)
+			continue
+		}
+		if columns[c] >= 0 {
+			return nil, nil, fmt.Errorf("'%s' and '%s' are both column %s", headers[columns[c]], header, r.mapping.Columns[c].Name)
+		}
+		columns[c] = i
+	}
+	return columns, unknown, nil
+}
+
+// findExtra finds the extra columns of the header, the ones matching no column of the mapping,
+// and keeps the ones ingested as tags.
+func (r *Reader) findExtra() {
+	mapped := make(map[int]bool)
+	for _, i := range r.columns {
+		mapped[i] = true
+	}
+	r.extra = nil
+	for i, header := range r.headers {
+		header = strings.TrimSpace(header)
+		if mapped[i] || header == "" {
+			continue
+		}
+		if r.mapping.Extra.IsTag(header) {
+			r.extra = append(r.extra, i)
+			r.extraTags = appendNew(r.extraTags, header)
+		} else {
+			r.ignored = appendNew(r.ignored, header)
+		}
+	}
+}
+
+// ExtraTags returns the headers of the extra columns ingested as tags, the columns matching
+// no column of the mapping, in the order of the file.
+func (r *Reader) ExtraTags() []string {
+	return r.extraTags
+}
+
+// IgnoredColumns returns the headers of the extra columns not ingested, as the mapping denies them.
+func (r *Reader) IgnoredColumns() []string {
+	return r.ignored
+}
+
+// missingColumns returns the required columns of the mapping not found in the headers.
+func (r *Reader) missingColumns(columns []int) []int {
+	var missing []int
+	for c, column := range r.mapping.Columns {
+		if column.Required && columns[c] < 0 {
+			missing = append(missing, c)
+		}
+	}
+	return missing
+}
+
+// ReadNext reads the next row and returns it as a Stream object or an error.
+// Empty rows are skipped. After an error that is not the error of a single row, such as a
+// truncated compressed file, the entry is closed and the reading ends: the next call returns io.EOF.
+func (r *Reader) ReadNext() (*stream.Stream, error) {
+	if r.failed {
+		return nil, io.EOF
+	}
+	var row []string
+	for {
+		if r.source == nil {
+			if r.entry+1 >= len(r.input.entries) {
+				return nil, io.EOF // End of file
+			}
+			// next table of the archive
+			if err := r.openEntry(r.entry + 1); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// Read the next record
+		cells, n, err := r.source.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				r.closeSource()
+				continue
+			}
+			r.row = n
+			readErr := NewCSVReaderError("failed to read row at "+r.position(n, -1), err)
+			if !isRowError(err) {
+				r.closeSource()
+				r.failed = true
+			}
+			return nil, readErr
+		}
+		r.row = n
+		if !isEmptyRow(cells) {
+			row = cells
+			break
+		}
+	}
+	if hasReplacement(row) {
+		r.replaced = append(r.replaced, r.Row())
+	}
+
+	// Convert the row into a Stream structure
+	streamRes, err := r.parseRow(row)
+	if err != nil {
+		return nil, NewCSVReaderError("failed to parse row into Stream", err)
+	}
+	return streamRes, nil
+}
+
+// Row returns the row of the source of the last stream read, for the header the row of the headers.
+// The rows of the entries of an archive are numbered one after the other.
+func (r *Reader) Row() int {
+	return r.offset + r.row
+}
+
+// Encoding returns the encoding the text table being read is decoded from, empty for a workbook.
+func (r *Reader) Encoding() string {
+	return r.decodedAs
+}
+
+// Replaced returns the rows read holding the replacement character U+FFFD, which stands for bytes
+// not valid in the encoding of the file: the encoding is likely wrong.
+func (r *Reader) Replaced() []int {
+	return r.replaced
+}
+
+// Size returns the size in bytes of the input file, -1 when unknown (stdin).
+func (r *Reader) Size() int64 {
+	return r.input.size
+}
+
+// Offset returns the bytes of the input file read so far, to report the progress of the reading.
+func (r *Reader) Offset() int64 {
+	return r.input.offset()
+}
+
+func isEmptyRow(cells []string) bool {
+	for _, cell := range cells {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRow converts a row into a Stream following the column mapping.
+// An empty cell takes the default value of its column; a field left empty keeps its zero value.
+// Without a Precision, the precision of the stream is the most decimals of its decimal fields,
+// set by the row as soon as one of them is.
+// The Patch of the stream tells the fields the row sets, the fields and tags it clears with stream.ClearValue
+// and leaves the others, of an empty cell or of a column the file does not have, as they are:
+// the default value of a column only fills a new stream.
+func (r *Reader) parseRow(row []string) (*stream.Stream, error) {
+	if len(row) < len(r.headers) {
+		// a workbook row ends at its last cell with a value
+		if r.input.entries[r.entry].format != FormatXLSX {
+			return nil, fmt.Errorf("%s: row does not contain enough columns, %d of %d", r.position(r.row, -1), len(row), len(r.headers))
+		}
+		for len(row) < len(r.headers) {
+			row = append(row, "")
+		}
+	}
+
+	// Creating a stream for the row representation
+	streamRes := stream.NewStream()
+	streamRes = streamRes.SetCreationBy(r.user)
+	streamRes.Patch = stream.Patch{}
+
+	var (
+		tags         []model.Tag
+		precision    int
+		hasPrecision bool
+		setsDecimal  bool // the row sets a decimal field
+	)
+	for c, column := range r.mapping.Columns {
+		i := r.columns[c]
+		if i < 0 {
+			continue
+		}
+		value, defaulted := row[i], false
+		if value == "" {
+			value, defaulted = column.Default, true
+		}
+		switch {
+		case column.Field != "" && strings.TrimSpace(value) == stream.ClearValue:
+			if err := streamRes.ClearField(column.Field); err != nil {
+				return nil, errors.Join(fmt.Errorf("failed to clear %s at %s", column.Name, r.position(r.row, i)), err)
+			}
+			streamRes.Patch[column.Field] = stream.FieldNull
+			hasPrecision = hasPrecision || column.Field == "precision"
+		case column.Field != "":
+			if value == "" {
+				continue
+			}
+			if stream.IsDecimalField(column.Field) {
+				number, decimals, err := normalizeDecimal(value, r.decimal)
+				if err != nil {
+					return nil, errors.Join(fmt.Errorf("failed to get %s at %s", column.Name, r.position(r.row, i)), err)
+				}
+				value, precision = number, max(precision, decimals)
+			}
+			hasPrecision = hasPrecision || column.Field == "precision"
+			if err := streamRes.SetField(column.Field, value); err != nil {
+				return nil, errors.Join(fmt.Errorf("failed to get %s at %s", column.Name, r.position(r.row, i)), err)
+			}
+			if !defaulted {
+				streamRes.Patch[column.Field] = stream.FieldPresent
+				setsDecimal = setsDecimal || stream.IsDecimalField(column.Field)
+			}
+		case column.Tag != "" && strings.TrimSpace(value) == stream.ClearValue:
+			streamRes.Patch[stream.TagField(column.Tag)] = stream.FieldNull
+		case column.Tag != "":
+			tags = append(tags, processTags(column.Tag, value, column.Split)...)
+		}
+	}
+	if !hasPrecision {
+		streamRes.Precision = precision
+		if setsDecimal {
+			streamRes.Patch["precision"] = stream.FieldPresent
+		}
+	}
+	// extra columns
+	for _, i := range r.extra {
+		if row[i] == "" {
+			continue
+		}
+		if strings.TrimSpace(row[i]) == stream.ClearValue {
+			streamRes.Patch[stream.TagField(strings.TrimSpace(r.headers[i]))] = stream.FieldNull
+			continue
+		}
+		tags = append(tags, processTags(strings.TrimSpace(r.headers[i]), row[i], r.mapping.Extra.split())...)
+	}
+	// free-form tags of a JSON object
+	if source, ok := r.source.(tagSource); ok {
+		tags = append(tags, source.rowTags()...)
+	}
+	streamRes.Tags = stream.NewTagSet(tags...)
+
+	return &streamRes, nil
+}
+
+// processTags returns the tags name of the value, one per part of the value when split is set.
+func processTags(name string, value string, split string) []model.Tag {
+	values := []string{value}
+	if split != "" {
+		values = strings.Split(value, split)
+	}
+	tags := make([]model.Tag, len(values))
+	for i, tag := range values {
+		tags[i] = model.Tag{
+			Name:  name,
+			Value: tag,
+		}
+	}
+	return tags
+}
+
+// appendNew appends value to values unless already there.
+func appendNew(values []string, value string) []string {
+	if contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
+
+// Close closes the input file.
+func (r *Reader) Close() error {
+	r.closeSource()
+	return r.input.Close()
+}
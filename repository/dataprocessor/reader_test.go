@@ -0,0 +1,280 @@
+package dataprocessor
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// testMapping is a small mapping, every other column is an extra tag.
+var testMapping = Mapping{Columns: []Column{
+	{Name: "SensorID", Field: "sensorId", Required: true},
+	{Name: "SiteCode", Field: "siteCode", Required: true},
+	{Name: "MinValue", Field: "minValue", Default: "0"},
+	{Name: "MaxValue", Field: "maxValue"},
+	{Name: "Precision", Field: "precision"},
+	{Name: "Area", Tag: "Area", Split: ","},
+}}
+
+// readAll reads every stream of the file holding data.
+func readAll(t *testing.T, name string, data string, options ReaderOptions) ([]*stream.Stream, error) {
+	t.Helper()
+	fileName := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(fileName, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return readFile(fileName, options)
+}
+
+// readFile reads every stream of the file, with testMapping when options has no mapping.
+func readFile(fileName string, options ReaderOptions) ([]*stream.Stream, error) {
+	if options.Mapping.Columns == nil {
+		options.Mapping = testMapping
+	}
+	r, err := NewReader(fileName, "tester", options)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var streams []*stream.Stream
+	for {
+		s, err := r.ReadNext()
+		if errors.Is(err, io.EOF) {
+			return streams, nil
+		}
+		if err != nil {
+			return streams, err
+		}
+		streams = append(streams, s)
+	}
+}
+
+func TestReaderHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []string // sensor IDs read
+		wantErr string
+	}{
+		{"first row", "SensorID,SiteCode\nT1,S1\n", []string{"T1"}, ""},
+		{"title rows", "Registry export\n\nsensor_id, Site Code \nT1,S1\nT2,S1\n", []string{"T1", "T2"}, ""},
+		{"any order", "SiteCode,SensorID\nS1,T1\n", []string{"T1"}, ""},
+		{"misspelled", "SensorID,SiteCod\nT1,S1\n", nil, "want 'SiteCode', did you mean 'SiteCod'?"},
+		{"missing", "SensorID,Comment\nT1,S1\n", nil, "want 'SiteCode'"},
+		{"duplicate", "SensorID,sensor_id,SiteCode\nT1,T1,S1\n", nil, "are both column SensorID"},
+		{"empty", "", nil, "is empty"},
+		{"short row", "SensorID,SiteCode,Area\nT1,S1\n", nil, "row does not contain enough columns, 2 of 3"},
+	}
+	for _, tt := range tests {
+		streams, err := readAll(t, "in.csv", tt.data, ReaderOptions{})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, s := range streams {
+			got = append(got, s.SensorID)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: read %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReaderXLSX(t *testing.T) {
+	book := excelize.NewFile()
+	defer book.Close()
+	if _, err := book.NewSheet("Registry"); err != nil {
+		t.Fatal(err)
+	}
+	rows := [][]any{
+		{"SensorID", "SiteCode", "MinValue", "Area"},
+		{"T1", "S1", 1.5, "a"},
+		{"T2", "S1"}, // a workbook row ends at its last cell with a value
+	}
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		if err := book.SetSheetRow("Registry", cell, &row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fileName := filepath.Join(t.TempDir(), "in.xlsx")
+	if err := book.SaveAs(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sheet := range []string{"Registry", "2"} {
+		streams, err := readFile(fileName, ReaderOptions{Sheet: sheet})
+		if err != nil {
+			t.Fatalf("sheet %s: %v", sheet, err)
+		}
+		if len(streams) != 2 || streams[0].MinValue != 1.5 || streams[0].Tags.Value("Area") != "a" || streams[1].SensorID != "T2" {
+			t.Errorf("sheet %s: read %+v", sheet, streams)
+		}
+	}
+	// the first sheet has no header
+	if _, err := readFile(fileName, ReaderOptions{}); err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Errorf("first sheet: error = %v, want an empty sheet", err)
+	}
+	if _, err := readFile(fileName, ReaderOptions{Sheet: "3"}); err == nil || !strings.Contains(err.Error(), "sheet 3 does not exist") {
+		t.Errorf("sheet 3: error = %v, want a missing sheet", err)
+	}
+}
+
+func TestReaderJSON(t *testing.T) {
+	data := `[{"SensorID": "T1", "SiteCode": "S1", "MinValue": 2.5},
+{"SensorID": "T2", "SiteCode": "S1"}]`
+	streams, err := readAll(t, "in.json", data, ReaderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 2 || streams[0].MinValue != 2.5 || streams[1].SensorID != "T2" {
+		t.Fatalf("read %+v", streams)
+	}
+	if streams[1].MinValue != 0 {
+		t.Errorf("a missing key is read as %v", streams[1].MinValue)
+	}
+}
+
+func TestReaderJSONL(t *testing.T) {
+	data := `{"SensorID": "T1", "SiteCode": "S1", "tags": {"Area": ["a", "b"], "Zone": "z"}}
+
+{"SensorID": "T2", "SiteCode": "S1", "tags": [{"name": "Area", "value": "c"}]}
+`
+	streams, err := readAll(t, "in.jsonl", data, ReaderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 2 {
+		t.Fatalf("read %d streams, want 2", len(streams))
+	}
+	if got := streams[0].Tags.Values("Area"); !reflect.DeepEqual(got, []string{"a", "b"}) || streams[0].Tags.Value("Zone") != "z" {
+		t.Errorf("T1 tags = %v", streams[0].Tags)
+	}
+	if got := streams[1].Tags.Values("Area"); !reflect.DeepEqual(got, []string{"c"}) {
+		t.Errorf("T2 tags = %v", streams[1].Tags)
+	}
+	if _, err := readAll(t, "in.jsonl", "{\"SensorID\": \"T1\"\n", ReaderOptions{}); err == nil {
+		t.Error("a truncated object is read")
+	}
+}
+
+func TestReaderExtraColumns(t *testing.T) {
+	data := "SensorID,SiteCode,Zone ,Comment,Owners,\nT1,S1,North,checked,\"ann,bob\",x\n"
+	fileName := filepath.Join(t.TempDir(), "in.csv")
+	if err := os.WriteFile(fileName, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mapping := testMapping
+	mapping.Extra = Extra{Deny: []string{"comment*"}}
+	r, err := NewReader(fileName, "tester", ReaderOptions{Mapping: mapping})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got := r.ExtraTags(); !reflect.DeepEqual(got, []string{"Zone", "Owners"}) {
+		t.Errorf("ExtraTags = %v, want Zone and Owners", got)
+	}
+	if got := r.IgnoredColumns(); !reflect.DeepEqual(got, []string{"Comment"}) {
+		t.Errorf("IgnoredColumns = %v, want Comment", got)
+	}
+	s, err := r.ReadNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Tags.Value("Zone") != "North" || !reflect.DeepEqual(s.Tags.Values("Owners"), []string{"ann", "bob"}) || s.Tags.Value("Comment") != "" {
+		t.Errorf("tags = %v", s.Tags)
+	}
+}
+
+func TestReaderPatch(t *testing.T) {
+	data := "SensorID,SiteCode,MinValue,MaxValue,Area,Zone\n" +
+		"T1,S1,1.5,10,a,z1\n" +
+		"T2,S1,,<clear>,<clear>,\n" +
+		"T3,S1,<clear>,,,<clear>\n"
+	streams, err := readAll(t, "in.csv", data, ReaderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		field string
+		want  []stream.FieldState // state for T1, T2 and T3
+	}{
+		{"sensorId", []stream.FieldState{stream.FieldPresent, stream.FieldPresent, stream.FieldPresent}},
+		{"minValue", []stream.FieldState{stream.FieldPresent, stream.FieldAbsent, stream.FieldNull}}, // the default is no update
+		{"maxValue", []stream.FieldState{stream.FieldPresent, stream.FieldNull, stream.FieldAbsent}},
+		{"precision", []stream.FieldState{stream.FieldPresent, stream.FieldAbsent, stream.FieldAbsent}}, // derived from the decimal fields set
+		{stream.TagField("Area"), []stream.FieldState{stream.FieldAbsent, stream.FieldNull, stream.FieldAbsent}},
+		{stream.TagField("Zone"), []stream.FieldState{stream.FieldAbsent, stream.FieldAbsent, stream.FieldNull}},
+	}
+	for _, tt := range tests {
+		for i, s := range streams {
+			if got := s.Patch.State(tt.field); got != tt.want[i] {
+				t.Errorf("%s of %s = %q, want %q", tt.field, s.SensorID, got, tt.want[i])
+			}
+		}
+	}
+	if streams[0].MinValue != 1.5 || streams[0].Tags.Value("Zone") != "z1" {
+		t.Errorf("T1 read %v and %v, want 1.5 and the extra tag z1", streams[0].MinValue, streams[0].Tags)
+	}
+	for _, s := range streams {
+		for _, tag := range s.Tags {
+			if tag.Value == stream.ClearValue {
+				t.Errorf("%s: <clear> read as the value of tag %s", s.SensorID, tag.Name)
+			}
+		}
+	}
+}
+
+func TestReaderValues(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		options   ReaderOptions
+		min, max  float64
+		precision int
+		tags      []string // values of the Area tag
+		wantErr   string
+	}{
+		{"dot", "SensorID,SiteCode,MinValue,MaxValue\nT1,S1,-1.25,100.5\n", ReaderOptions{}, -1.25, 100.5, 2, nil, ""},
+		{"comma", "SensorID,SiteCode,MinValue,MaxValue\nT1,S1,\"0,5\",\"1.000,25\"\n", ReaderOptions{Decimal: DecimalComma}, 0.5, 1000.25, 2, nil, ""},
+		{"precision column", "SensorID,SiteCode,MinValue,Precision\nT1,S1,0.125,1\n", ReaderOptions{}, 0.125, 0, 1, nil, ""},
+		{"default", "SensorID,SiteCode,MinValue\nT1,S1,\n", ReaderOptions{}, 0, 0, 0, nil, ""},
+		{"split tag", "SensorID,SiteCode,Area\nT1,S1,\"b,a,b\"\n", ReaderOptions{}, 0, 0, 0, []string{"a", "b"}, ""},
+		{"not a number", "SensorID,SiteCode,MinValue\nT1,S1,low\n", ReaderOptions{}, 0, 0, 0, nil, "failed to get MinValue"},
+	}
+	for _, tt := range tests {
+		streams, err := readAll(t, "in.csv", tt.data, tt.options)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || len(streams) != 1 {
+			t.Errorf("%s: read %d streams, %v", tt.name, len(streams), err)
+			continue
+		}
+		s := streams[0]
+		if s.MinValue != tt.min || s.MaxValue != tt.max || s.Precision != tt.precision {
+			t.Errorf("%s: read %v %v precision %d, want %v %v precision %d", tt.name, s.MinValue, s.MaxValue, s.Precision, tt.min, tt.max, tt.precision)
+		}
+		if got := s.Tags.Values("Area"); !reflect.DeepEqual(got, tt.tags) && (len(got) != 0 || len(tt.tags) != 0) {
+			t.Errorf("%s: Area = %v, want %v", tt.name, got, tt.tags)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package dataprocessor
+
+import (
+	"encoding/csv"
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// RowSource is a table read row by row: the rows of a CSV file, of a workbook sheet or the objects of a JSON file.
+type RowSource interface {
+	// Read returns the cells of the next row and its number in the source (its line in a text file,
+	// 1 for the first row), io.EOF after the last row. The error of a row that cannot be read
+	// (see isRowError) lets the next rows be read, any other error ends the source.
+	Read() ([]string, int, error)
+	// Position describes where the cell col (0 based) of row is, for error messages.
+	// A negative col describes the whole row.
+	Position(row int, col int) string
+	// Name describes the source, for error messages.
+	Name() string
+	Close() error
+}
+
+// rowError is the error of a single row of a source, such as an object of a JSON file that cannot be read.
+type rowError struct {
+	err error
+}
+
+func (e *rowError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rowError) Unwrap() error {
+	return e.err
+}
+
+// isRowError tells whether the error returned by the Read of a source is the error of a single row,
+// a CSV syntax error or a rowError, after which the next rows can be read. Any other error, such as
+// a truncated or corrupt compressed file, ends the source.
+func isRowError(err error) bool {
+	var parseErr *csv.ParseError
+	var rowErr *rowError
+	return errors.As(err, &parseErr) || errors.As(err, &rowErr)
+}
+
+// Input formats
+const (
+	FormatCSV   = "csv"
+	FormatXLSX  = "xlsx"
+	FormatJSON  = "json"
+	FormatJSONL = "jsonl"
+)
+
+// Formats returns the input formats.
+func Formats() []string {
+	return []string{FormatCSV, FormatXLSX, FormatJSON, FormatJSONL}
+}
+
+// fileFormat returns the format of the file from its extension, CSV when the extension is unknown.
+func fileFormat(filePath string) string {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".xlsx":
+		return FormatXLSX
+	case ".json":
+		return FormatJSON
+	case ".jsonl", ".ndjson":
+		return FormatJSONL
+	default:
+		return FormatCSV
+	}
+}
@@ -0,0 +1,100 @@
+package dataprocessor
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// xlsxSource reads the rows of a sheet of an Excel workbook. Cells are read with their
+// number format applied, so a SensorID formatted with leading zeros keeps them.
+type xlsxSource struct {
+	file  *excelize.File
+	sheet string
+	rows  *excelize.Rows
+	row   int
+}
+
+// newXLSXSource opens the sheet of the workbook, given by name or 1 based index, the first sheet by default.
+// The workbook is read in memory.
+func newXLSXSource(fileName string, r io.ReadCloser, sheet string) (*xlsxSource, error) {
+	file, err := excelize.OpenReader(r)
+	r.Close()
+	if err != nil {
+		return nil, NewCSVReaderError("open "+fileName, err)
+	}
+
+	name, err := sheetName(file, sheet)
+	if err != nil {
+		file.Close()
+		return nil, NewCSVReaderError("open sheet", err)
+	}
+	rows, err := file.Rows(name)
+	if err != nil {
+		file.Close()
+		return nil, NewCSVReaderError("failed to read sheet "+name, err)
+	}
+	return &xlsxSource{file: file, sheet: name, rows: rows}, nil
+}
+
+func (s *xlsxSource) Read() ([]string, int, error) {
+	if !s.rows.Next() {
+		if err := s.rows.Error(); err != nil {
+			return nil, s.row + 1, err
+		}
+		return nil, 0, io.EOF
+	}
+	s.row++
+	cells, err := s.rows.Columns()
+	if err != nil {
+		return nil, s.row, err
+	}
+	return cells, s.row, nil
+}
+
+func (s *xlsxSource) Position(row int, col int) string {
+	if col < 0 {
+		return fmt.Sprintf("sheet %s, row %d", s.sheet, row)
+	}
+	column, err := excelize.ColumnNumberToName(col + 1)
+	if err != nil {
+		column = strconv.Itoa(col + 1)
+	}
+	return fmt.Sprintf("sheet %s, row %d, column %s", s.sheet, row, column)
+}
+
+func (s *xlsxSource) Name() string {
+	return "sheet " + s.sheet
+}
+
+func (s *xlsxSource) Close() error {
+	if s.rows != nil {
+		_ = s.rows.Close()
+	}
+	return s.file.Close()
+}
+
+// sheetName returns the name of the sheet given by name or 1 based index, the first sheet when empty.
+func sheetName(file *excelize.File, sheet string) (string, error) {
+	sheets := file.GetSheetList()
+	if len(sheets) == 0 {
+		return "", fmt.Errorf("the workbook has no sheet")
+	}
+	if sheet == "" {
+		return sheets[0], nil
+	}
+	for _, name := range sheets {
+		if name == sheet {
+			return name, nil
+		}
+	}
+	if i, err := strconv.Atoi(sheet); err == nil {
+		if i < 1 || i > len(sheets) {
+			return "", fmt.Errorf("sheet %d does not exist, the workbook has %d sheets", i, len(sheets))
+		}
+		return sheets[i-1], nil
+	}
+	return "", fmt.Errorf("sheet %q does not exist, the workbook has %q", sheet, sheets)
+}
@@ -0,0 +1,272 @@
+package filestore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
+	"githb.com/Go-routine-4595/stream-ingest/repository/memory"
+
+	"github.com/rs/zerolog/log"
+)
+
+const fileExt = ".jsonl"
+
+var _ repository.StreamRepository = (*Repository)(nil)
+
+var _ repository.Flusher = (*Repository)(nil)
+
+// Repository is a StreamRepository persisted in a local directory.
+// Each SiteCode partition is stored in its own JSONL file (one stream or history document per line),
+// sorted by SensorID so the directory can be reviewed and diffed in git.
+// Writes are kept in memory, the partitions they touch are rewritten once by Flush or Close.
+type Repository struct {
+	dir   string
+	mu    sync.Mutex
+	store *memory.Repository
+	dirty map[string]bool // sites written since the last flush
+}
+
+// NewRepository opens the store located in dir, creating the directory if needed,
+// and loads every partition file it contains.
+func NewRepository(dir string) (*Repository, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Join(errors.New("failed to create store directory"), err)
+	}
+
+	r := &Repository{
+		dir:   dir,
+		store: memory.NewRepository(),
+		dirty: make(map[string]bool),
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+fileExt))
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to list store directory"), err)
+	}
+	for _, f := range files {
+		streams, entries, err := readPartition(f)
+		if err != nil {
+			return nil, err
+		}
+		if err = r.store.Load(streams); err != nil {
+			return nil, err
+		}
+		if errs := r.store.CreateHistory(entries); len(errs) > 0 {
+			return nil, errors.Join(errs...)
+		}
+	}
+
+	return r, nil
+}
+
+// GetStreamByStreamIdAndSiteCode returns every stream of the siteCode partition having the given sensorId.
+func (r *Repository) GetStreamByStreamIdAndSiteCode(sensorId string, siteCode string) ([]stream.Stream, error) {
+	return r.store.GetStreamByStreamIdAndSiteCode(sensorId, siteCode)
+}
+
+// QueryStreams returns the streams matching the filter sorted by SiteCode then SensorID.
+func (r *Repository) QueryStreams(filter repository.StreamFilter) ([]stream.Stream, error) {
+	return r.store.QueryStreams(filter)
+}
+
+// TagNames returns the sorted names of the tags held by at least one stream.
+func (r *Repository) TagNames() ([]string, error) {
+	return r.store.TagNames()
+}
+
+func (r *Repository) UpdateStreamsByStreamKey(streams []stream.Stream) []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.markDirty(streams)
+	return r.store.UpdateStreamsByStreamKey(streams)
+}
+
+func (r *Repository) DeleteStreamsByStreamKey(streams []stream.Stream) []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.markDirty(streams)
+	return r.store.DeleteStreamsByStreamKey(streams)
+}
+
+func (r *Repository) CreatStreamsByStreamKey(streams []stream.Stream) []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.markDirty(streams)
+	return r.store.CreatStreamsByStreamKey(streams)
+}
+
+func (r *Repository) WriteBatchedStreamsByStreamKey(op repository.BatchOperation, streams []stream.Stream) []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.markDirty(streams)
+	return r.store.WriteBatchedStreamsByStreamKey(op, streams)
+}
+
+// CreateHistory stores the history entries in the partition of their stream, replacing an entry with the same ID.
+func (r *Repository) CreateHistory(entries []stream.History) []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, entry := range entries {
+		r.dirty[entry.SiteCode] = true
+	}
+	return r.store.CreateHistory(entries)
+}
+
+// GetHistory returns the previous versions of the stream streamID of the siteCode partition sorted by Version.
+func (r *Repository) GetHistory(streamID string, siteCode string) ([]stream.History, error) {
+	return r.store.GetHistory(streamID, siteCode)
+}
+
+// Close flushes the writes not saved yet, logging the partitions that cannot be saved.
+func (r *Repository) Close() {
+	for _, err := range r.Flush() {
+		log.Logger.Err(err).Msg("failed to save partition")
+	}
+}
+
+// markDirty records the sites of streams as written since the last flush.
+func (r *Repository) markDirty(streams []stream.Stream) {
+	for _, streamEle := range streams {
+		r.dirty[streamEle.SiteCode] = true
+	}
+}
+
+// Flush rewrites the partition file of each site written since the last flush.
+// A partition that cannot be saved stays to flush.
+func (r *Repository) Flush() []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var errs []error
+	sites := make([]string, 0, len(r.dirty))
+	for site := range r.dirty {
+		sites = append(sites, site)
+	}
+	sort.Strings(sites)
+
+	for _, site := range sites {
+		partition, err := r.store.StreamsBySiteCode(site)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		entries, err := r.store.HistoryBySiteCode(site)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err = writePartition(filepath.Join(r.dir, partitionFileName(site)), partition, entries); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delete(r.dirty, site)
+	}
+
+	return errs
+}
+
+// partitionFileName returns the file name used to store the siteCode partition.
+func partitionFileName(siteCode string) string {
+	if siteCode == "" {
+		return "_" + fileExt
+	}
+	return url.PathEscape(siteCode) + fileExt
+}
+
+// readPartition reads one stream or history document per non-empty line of the file.
+func readPartition(fileName string) ([]stream.Stream, []stream.History, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, errors.Join(errors.New("failed to open partition file"), err)
+	}
+	defer f.Close()
+
+	var (
+		streams []stream.Stream
+		entries []stream.History
+	)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var entry stream.History
+		if err = json.Unmarshal(data, &entry); err != nil {
+			return nil, nil, errors.Join(fmt.Errorf("failed to unmarshal %s line %d", fileName, line), err)
+		}
+		if entry.RegistryType == stream.RegistryTypeStreamHistory {
+			entries = append(entries, entry)
+			continue
+		}
+		streams = append(streams, entry.Stream)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, nil, errors.Join(fmt.Errorf("failed to read %s", fileName), err)
+	}
+	return streams, entries, nil
+}
+
+// writePartition replaces the file with the streams and their history sorted by SensorID then ID.
+// The content is written to a temporary file first so a failure never leaves a truncated partition.
+func writePartition(fileName string, streams []stream.Stream, entries []stream.History) error {
+	type document struct {
+		sensorID string
+		id       string
+		data     []byte
+	}
+
+	docs := make([]document, 0, len(streams)+len(entries))
+	for _, streamEle := range streams {
+		itemData, err := json.Marshal(streamEle)
+		if err != nil {
+			return errors.Join(errors.New("failed to marshal item in repository writePartition"), err)
+		}
+		docs = append(docs, document{sensorID: streamEle.SensorID, id: streamEle.ID, data: itemData})
+	}
+	for _, entry := range entries {
+		itemData, err := json.Marshal(entry)
+		if err != nil {
+			return errors.Join(errors.New("failed to marshal item in repository writePartition"), err)
+		}
+		docs = append(docs, document{sensorID: entry.SensorID, id: entry.ID, data: itemData})
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		if docs[i].sensorID != docs[j].sensorID {
+			return docs[i].sensorID < docs[j].sensorID
+		}
+		return docs[i].id < docs[j].id
+	})
+
+	var buf strings.Builder
+	for _, doc := range docs {
+		buf.Write(doc.data)
+		buf.WriteByte('\n')
+	}
+
+	tmp := fileName + ".tmp"
+	if err := os.WriteFile(tmp, []byte(buf.String()), 0o644); err != nil {
+		return errors.Join(fmt.Errorf("failed to write %s", tmp), err)
+	}
+	if err := os.Rename(tmp, fileName); err != nil {
+		return errors.Join(fmt.Errorf("failed to replace %s", fileName), err)
+	}
+	return nil
+}
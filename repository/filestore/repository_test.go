@@ -0,0 +1,162 @@
+package filestore
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
+)
+
+func newStream(id string, siteCode string, sensorID string) stream.Stream {
+	s := stream.NewStream()
+	s.ID, s.SiteCode, s.SensorID = id, siteCode, sensorID
+	return s
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := r.CreatStreamsByStreamKey([]stream.Stream{
+		newStream("2", "S1", "T2"),
+		newStream("1", "S1", "T1"),
+		newStream("3", "S/2", "T3"),
+		newStream("4", "", "T4"),
+	}); len(errs) != 0 {
+		t.Fatalf("create: %v", errs)
+	}
+	got, _ := r.GetStreamByStreamIdAndSiteCode("T2", "S1")
+	updated := got[0]
+	updated.StreamName = "v2"
+	if errs := r.CreateHistory([]stream.History{stream.NewHistory(got[0])}); len(errs) != 0 {
+		t.Fatalf("history: %v", errs)
+	}
+	if errs := r.UpdateStreamsByStreamKey([]stream.Stream{updated}); len(errs) != 0 {
+		t.Fatalf("update: %v", errs)
+	}
+	r.Close()
+
+	tests := []struct {
+		file  string
+		lines []string // sensor IDs of the documents, in file order
+	}{
+		{"S1.jsonl", []string{"T1", "T2", "T2"}},
+		{"S%2F2.jsonl", []string{"T3"}},
+		{"_.jsonl", []string{"T4"}},
+	}
+	for _, tt := range tests {
+		data, err := os.ReadFile(filepath.Join(dir, tt.file))
+		if err != nil {
+			t.Errorf("partition file: %v", err)
+			continue
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != len(tt.lines) {
+			t.Errorf("%s has %d documents, want %d", tt.file, len(lines), len(tt.lines))
+			continue
+		}
+		for i, sensorID := range tt.lines {
+			if !strings.Contains(lines[i], `"sensorId":"`+sensorID+`"`) {
+				t.Errorf("%s line %d is %s, want sensor %s", tt.file, i+1, lines[i], sensorID)
+			}
+		}
+	}
+
+	reopened, err := NewRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := reopened.QueryStreams(repository.StreamFilter{})
+	if err != nil || len(all) != 4 {
+		t.Fatalf("reopened store has %d streams, %v, want 4", len(all), err)
+	}
+	got, _ = reopened.GetStreamByStreamIdAndSiteCode("T2", "S1")
+	if len(got) != 1 || got[0].StreamName != "v2" || got[0].ETag == "" {
+		t.Errorf("reopened T2 = %+v, want v2 with an ETag", got)
+	}
+	entries, err := reopened.GetHistory(got[0].ID, "S1")
+	if err != nil || len(entries) != 1 || entries[0].StreamName != "" {
+		t.Errorf("reopened history = %+v, %v, want the first version", entries, err)
+	}
+	// the ETag read back still guards the document
+	if errs := reopened.UpdateStreamsByStreamKey([]stream.Stream{updated}); len(errs) != 1 {
+		t.Errorf("update with a stale ETag = %v, want a conflict", errs)
+	}
+}
+
+func TestDeleteEmptiesPartition(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newStream("1", "S1", "T1")
+	if errs := r.CreatStreamsByStreamKey([]stream.Stream{s}); len(errs) != 0 {
+		t.Fatalf("create: %v", errs)
+	}
+	got, _ := r.GetStreamByStreamIdAndSiteCode("T1", "S1")
+	if errs := r.DeleteStreamsByStreamKey(got); len(errs) != 0 {
+		t.Fatalf("delete: %v", errs)
+	}
+	r.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, "S1.jsonl"))
+	if err != nil || len(data) != 0 {
+		t.Errorf("partition file = %q, %v, want it empty", data, err)
+	}
+}
+
+func TestCorruptPartition(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "S1.jsonl"), []byte("{\"id\":\"1\"}\n\nnot json\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRepository(dir); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("NewRepository error = %v, want the corrupt line", err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		r.CreatStreamsByStreamKey([]stream.Stream{newStream(strconv.Itoa(i), "S1", "T"+strconv.Itoa(i))})
+	}
+	// the writes are saved once, by Flush
+	if _, err = os.Stat(filepath.Join(dir, "S1.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("partition written before Flush: %v", err)
+	}
+	// a partition that cannot be saved stays to flush
+	if err = os.Mkdir(filepath.Join(dir, "S1.jsonl.tmp"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if errs := r.Flush(); len(errs) != 1 {
+		t.Errorf("Flush = %v, want the error of S1", errs)
+	}
+	os.Remove(filepath.Join(dir, "S1.jsonl.tmp"))
+	if errs := r.Flush(); len(errs) != 0 {
+		t.Fatalf("Flush: %v", errs)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "S1.jsonl"))
+	if err != nil || strings.Count(string(data), "\n") != 3 {
+		t.Errorf("S1.jsonl = %q, %v, want 3 streams", data, err)
+	}
+
+	// nothing written since, nothing to save
+	os.Remove(filepath.Join(dir, "S1.jsonl"))
+	if errs := r.Flush(); len(errs) != 0 {
+		t.Fatalf("Flush: %v", errs)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "S1.jsonl")); !os.IsNotExist(err) {
+		t.Error("a partition not written since the last flush is saved again")
+	}
+}
@@ -0,0 +1,398 @@
+package memory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
+
+	"github.com/google/uuid"
+)
+
+var _ repository.StreamRepository = (*Repository)(nil)
+
+// Repository is a thread-safe in-memory StreamRepository.
+// Documents are kept marshaled per SiteCode partition, so streams read back
+// look exactly like the ones returned by Cosmos.
+type Repository struct {
+	mu         sync.RWMutex
+	partitions map[string]map[string][]byte
+}
+
+func NewRepository() *Repository {
+	return &Repository{
+		partitions: make(map[string]map[string][]byte),
+	}
+}
+
+// Load seeds the repository with existing streams, replacing documents with the same ID.
+// Streams loaded without an ETag are given one derived from their content, so it is stable across loads.
+func (r *Repository) Load(streams []stream.Stream) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, streamEle := range streams {
+		itemData, err := json.Marshal(streamEle)
+		if err != nil {
+			return errors.Join(errors.New("failed to marshal item in repository Load"), err)
+		}
+		if streamEle.ETag == "" {
+			streamEle.ETag = "\"" + uuid.NewSHA1(uuid.NameSpaceOID, itemData).String() + "\""
+			if itemData, err = json.Marshal(streamEle); err != nil {
+				return errors.Join(errors.New("failed to marshal item in repository Load"), err)
+			}
+		}
+		r.partition(streamEle.SiteCode)[streamEle.ID] = itemData
+	}
+	return nil
+}
+
+// GetStreamByStreamIdAndSiteCode returns every stream of the siteCode partition having the given sensorId.
+func (r *Repository) GetStreamByStreamIdAndSiteCode(sensorId string, siteCode string) ([]stream.Stream, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	streams := make([]stream.Stream, 0)
+	for _, itemData := range r.partitions[siteCode] {
+		var streamEl stream.Stream
+		err := json.Unmarshal(itemData, &streamEl)
+		if err != nil {
+			return nil, errors.Join(errors.New("failed to unmarshal item in repository GetStreamByStreamIdAndSiteCode"), err)
+		}
+		if streamEl.SensorID == sensorId && streamEl.RegistryType != stream.RegistryTypeStreamHistory {
+			streams = append(streams, streamEl)
+		}
+	}
+	return streams, nil
+}
+
+func (r *Repository) UpdateStreamsByStreamKey(streams []stream.Stream) []error {
+	var errs []error
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, streamEle := range streams {
+		ifMatch := streamEle.ETag
+		streamEle.ETag = newETag()
+		itemData, err := json.Marshal(streamEle)
+		if err != nil {
+			lerr := errors.Join(errors.New("failed to marshal item in repository UpdateStreamsByStreamKey"), err)
+			errs = append(errs, repository.NewItemError(streamEle, 0, lerr))
+			continue
+		}
+		stored, ok := r.partitions[streamEle.SiteCode][streamEle.ID]
+		if !ok {
+			lerr := errors.Join(errors.New("failed to replace item in repository UpdateStreamsByStreamKey"), fmt.Errorf("%w: id %s site %s", repository.ErrNotFound, streamEle.ID, streamEle.SiteCode))
+			errs = append(errs, repository.NewItemError(streamEle, http.StatusNotFound, lerr))
+			continue
+		}
+		if ifMatch != "" && storedETag(stored) != ifMatch {
+			lerr := errors.Join(errors.New("failed to replace item in repository UpdateStreamsByStreamKey"), fmt.Errorf("%w: id %s site %s", repository.ErrPreconditionFailed, streamEle.ID, streamEle.SiteCode))
+			errs = append(errs, repository.NewItemError(streamEle, http.StatusPreconditionFailed, lerr))
+			continue
+		}
+		r.partitions[streamEle.SiteCode][streamEle.ID] = itemData
+	}
+
+	return errs
+}
+
+func (r *Repository) DeleteStreamsByStreamKey(streams []stream.Stream) []error {
+	var errs []error
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, streamEle := range streams {
+		stored, ok := r.partitions[streamEle.SiteCode][streamEle.ID]
+		if !ok {
+			lerr := errors.Join(errors.New("failed to delete item in repository DeleteStreamsByStreamKey"), fmt.Errorf("%w: id %s site %s", repository.ErrNotFound, streamEle.ID, streamEle.SiteCode))
+			errs = append(errs, repository.NewItemError(streamEle, http.StatusNotFound, lerr))
+			continue
+		}
+		if streamEle.ETag != "" && storedETag(stored) != streamEle.ETag {
+			lerr := errors.Join(errors.New("failed to delete item in repository DeleteStreamsByStreamKey"), fmt.Errorf("%w: id %s site %s", repository.ErrPreconditionFailed, streamEle.ID, streamEle.SiteCode))
+			errs = append(errs, repository.NewItemError(streamEle, http.StatusPreconditionFailed, lerr))
+			continue
+		}
+		delete(r.partitions[streamEle.SiteCode], streamEle.ID)
+	}
+
+	return errs
+}
+
+func (r *Repository) CreatStreamsByStreamKey(streams []stream.Stream) []error {
+	var errs []error
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, streamEle := range streams {
+		streamEle.ETag = newETag()
+		itemData, err := json.Marshal(streamEle)
+		if err != nil {
+			lerr := errors.Join(errors.New("failed to marshal item in repository CreatStreamsByStreamKey"), err)
+			errs = append(errs, repository.NewItemError(streamEle, 0, lerr))
+			continue
+		}
+		partition := r.partition(streamEle.SiteCode)
+		if _, ok := partition[streamEle.ID]; ok {
+			lerr := errors.Join(errors.New("failed to insert item in repository CreatStreamsByStreamKey"), fmt.Errorf("%w: id %s site %s", repository.ErrConflict, streamEle.ID, streamEle.SiteCode))
+			errs = append(errs, repository.NewItemError(streamEle, http.StatusConflict, lerr))
+			continue
+		}
+		partition[streamEle.ID] = itemData
+	}
+
+	return errs
+}
+
+// WriteBatchedStreamsByStreamKey writes the streams of each partition atomically:
+// if one stream of a partition cannot be written, none of that partition is and every
+// stream of the partition gets an ItemError, like a failed Cosmos transactional batch.
+func (r *Repository) WriteBatchedStreamsByStreamKey(op repository.BatchOperation, streams []stream.Stream) []error {
+	var errs []error
+
+	grouped := make(map[string][]stream.Stream)
+	for _, streamItem := range streams {
+		grouped[streamItem.SiteCode] = append(grouped[streamItem.SiteCode], streamItem)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for site, batch := range grouped {
+		var (
+			cause       error
+			causeStream stream.Stream
+		)
+		items := make(map[string][]byte, len(batch))
+		for _, streamEle := range batch {
+			ifMatch := streamEle.ETag
+			streamEle.ETag = newETag()
+			itemData, err := json.Marshal(streamEle)
+			if err != nil {
+				cause = errors.Join(errors.New("failed to marshal item in repository WriteBatchedStreamsByStreamKey"), err)
+			} else {
+				_, inBatch := items[streamEle.ID]
+				_, stored := r.partitions[site][streamEle.ID]
+				switch {
+				case inBatch:
+					cause = fmt.Errorf("%w: id %s site %s is twice in the batch", repository.ErrConflict, streamEle.ID, site)
+				case op == repository.BatchCreate && stored:
+					cause = fmt.Errorf("%w: id %s site %s", repository.ErrConflict, streamEle.ID, site)
+				case op == repository.BatchReplace && !stored:
+					cause = fmt.Errorf("%w: id %s site %s", repository.ErrNotFound, streamEle.ID, site)
+				case op == repository.BatchReplace && ifMatch != "" && storedETag(r.partitions[site][streamEle.ID]) != ifMatch:
+					cause = fmt.Errorf("%w: id %s site %s", repository.ErrPreconditionFailed, streamEle.ID, site)
+				case op != repository.BatchCreate && op != repository.BatchReplace && op != repository.BatchUpsert:
+					cause = fmt.Errorf("unknown batch operation %s", op)
+				}
+			}
+			if cause != nil {
+				causeStream = streamEle
+				break
+			}
+			items[streamEle.ID] = itemData
+		}
+		if cause != nil {
+			for _, streamEle := range batch {
+				if streamEle.ID == causeStream.ID {
+					errs = append(errs, repository.NewItemError(streamEle, causeStatus(cause), cause))
+					continue
+				}
+				lerr := fmt.Errorf("item not written in repository WriteBatchedStreamsByStreamKey: batch rolled back because of %s", causeStream.ID)
+				errs = append(errs, repository.NewItemError(streamEle, http.StatusFailedDependency, lerr))
+			}
+			continue
+		}
+		partition := r.partition(site)
+		for id, itemData := range items {
+			partition[id] = itemData
+		}
+	}
+
+	return errs
+}
+
+// QueryStreams returns the streams matching the filter sorted by SiteCode then SensorID.
+func (r *Repository) QueryStreams(filter repository.StreamFilter) ([]stream.Stream, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	partitions := r.partitions
+	if filter.SiteCode != "" {
+		partitions = map[string]map[string][]byte{filter.SiteCode: r.partitions[filter.SiteCode]}
+	}
+
+	streams := make([]stream.Stream, 0)
+	for _, partition := range partitions {
+		for _, itemData := range partition {
+			var streamEl stream.Stream
+			if err := json.Unmarshal(itemData, &streamEl); err != nil {
+				return nil, errors.Join(errors.New("failed to unmarshal item in repository QueryStreams"), err)
+			}
+			if filter.Match(streamEl) {
+				streams = append(streams, streamEl)
+			}
+		}
+	}
+	repository.SortStreams(streams)
+	return streams, nil
+}
+
+// Streams returns a snapshot of every stored stream.
+func (r *Repository) Streams() ([]stream.Stream, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	streams := make([]stream.Stream, 0)
+	for _, partition := range r.partitions {
+		for _, itemData := range partition {
+			var streamEl stream.Stream
+			if err := json.Unmarshal(itemData, &streamEl); err != nil {
+				return nil, errors.Join(errors.New("failed to unmarshal item in repository Streams"), err)
+			}
+			if streamEl.RegistryType == stream.RegistryTypeStreamHistory {
+				continue
+			}
+			streams = append(streams, streamEl)
+		}
+	}
+	return streams, nil
+}
+
+// StreamsBySiteCode returns a snapshot of the streams stored in the siteCode partition.
+func (r *Repository) StreamsBySiteCode(siteCode string) ([]stream.Stream, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	streams := make([]stream.Stream, 0, len(r.partitions[siteCode]))
+	for _, itemData := range r.partitions[siteCode] {
+		var streamEl stream.Stream
+		if err := json.Unmarshal(itemData, &streamEl); err != nil {
+			return nil, errors.Join(errors.New("failed to unmarshal item in repository StreamsBySiteCode"), err)
+		}
+		if streamEl.RegistryType == stream.RegistryTypeStreamHistory {
+			continue
+		}
+		streams = append(streams, streamEl)
+	}
+	return streams, nil
+}
+
+// TagNames returns the sorted names of the tags held by at least one stream.
+func (r *Repository) TagNames() ([]string, error) {
+	streams, err := r.Streams()
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to read streams in repository TagNames"), err)
+	}
+	var names []string
+	for _, s := range streams {
+		names = append(names, s.Tags.Names()...)
+	}
+	return repository.UniqueNames(names), nil
+}
+
+// CreateHistory stores the history entries in the partition of their stream, replacing an entry with the same ID.
+func (r *Repository) CreateHistory(entries []stream.History) []error {
+	var errs []error
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, entry := range entries {
+		itemData, err := json.Marshal(entry)
+		if err != nil {
+			lerr := errors.Join(errors.New("failed to marshal item in repository CreateHistory"), err)
+			errs = append(errs, repository.NewItemError(entry.Stream, 0, lerr))
+			continue
+		}
+		r.partition(entry.SiteCode)[entry.ID] = itemData
+	}
+
+	return errs
+}
+
+// GetHistory returns the previous versions of the stream streamID of the siteCode partition sorted by Version.
+func (r *Repository) GetHistory(streamID string, siteCode string) ([]stream.History, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := make([]stream.History, 0)
+	for _, itemData := range r.partitions[siteCode] {
+		var entry stream.History
+		if err := json.Unmarshal(itemData, &entry); err != nil {
+			return nil, errors.Join(errors.New("failed to unmarshal item in repository GetHistory"), err)
+		}
+		if entry.RegistryType == stream.RegistryTypeStreamHistory && entry.StreamID == streamID {
+			entries = append(entries, entry)
+		}
+	}
+	repository.SortHistory(entries)
+	return entries, nil
+}
+
+// HistoryBySiteCode returns a snapshot of the history entries stored in the siteCode partition.
+func (r *Repository) HistoryBySiteCode(siteCode string) ([]stream.History, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := make([]stream.History, 0)
+	for _, itemData := range r.partitions[siteCode] {
+		var entry stream.History
+		if err := json.Unmarshal(itemData, &entry); err != nil {
+			return nil, errors.Join(errors.New("failed to unmarshal item in repository HistoryBySiteCode"), err)
+		}
+		if entry.RegistryType == stream.RegistryTypeStreamHistory {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// storedETag returns the ETag of a stored document.
+func storedETag(itemData []byte) string {
+	var doc struct {
+		ETag string `json:"_etag"`
+	}
+	_ = json.Unmarshal(itemData, &doc)
+	return doc.ETag
+}
+
+// causeStatus returns the HTTP status Cosmos would give to the failure of a batch operation.
+func causeStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, repository.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	}
+	return 0
+}
+
+// newETag returns a new opaque version tag shaped like the Cosmos ones.
+func newETag() string {
+	return "\"" + uuid.NewString() + "\""
+}
+
+// partition returns the partition for siteCode, creating it if needed. The caller must hold the write lock.
+func (r *Repository) partition(siteCode string) map[string][]byte {
+	partition, ok := r.partitions[siteCode]
+	if !ok {
+		partition = make(map[string][]byte)
+		r.partitions[siteCode] = partition
+	}
+	return partition
+}
+
+func (r *Repository) Close() {
+
+}
@@ -0,0 +1,193 @@
+package memory
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+	"githb.com/Go-routine-4595/stream-ingest/repository"
+)
+
+func newStream(id string, siteCode string, sensorID string) stream.Stream {
+	s := stream.NewStream()
+	s.ID, s.SiteCode, s.SensorID = id, siteCode, sensorID
+	return s
+}
+
+// itemStatus returns the status of the ItemError err, -1 when err is not one.
+func itemStatus(err error) int {
+	var itemErr *repository.ItemError
+	if !errors.As(err, &itemErr) {
+		return -1
+	}
+	return itemErr.StatusCode
+}
+
+func TestPartitions(t *testing.T) {
+	r := NewRepository()
+	errs := r.CreatStreamsByStreamKey([]stream.Stream{
+		newStream("1", "S1", "T1"),
+		newStream("2", "S2", "T1"),
+		newStream("1", "S2", "T2"), // same ID in another partition
+	})
+	if len(errs) != 0 {
+		t.Fatalf("create: %v", errs)
+	}
+
+	tests := []struct {
+		sensorID, siteCode string
+		want               []string
+	}{
+		{"T1", "S1", []string{"1"}},
+		{"T1", "S2", []string{"2"}},
+		{"T2", "S1", nil},
+		{"T2", "S2", []string{"1"}},
+		{"T1", "S3", nil},
+	}
+	for _, tt := range tests {
+		got, err := r.GetStreamByStreamIdAndSiteCode(tt.sensorID, tt.siteCode)
+		if err != nil {
+			t.Fatalf("get %s %s: %v", tt.sensorID, tt.siteCode, err)
+		}
+		var ids []string
+		for _, s := range got {
+			ids = append(ids, s.ID)
+		}
+		if len(ids) != len(tt.want) || (len(ids) > 0 && ids[0] != tt.want[0]) {
+			t.Errorf("get %s %s = %v, want %v", tt.sensorID, tt.siteCode, ids, tt.want)
+		}
+	}
+}
+
+func TestCreateConflict(t *testing.T) {
+	r := NewRepository()
+	if errs := r.CreatStreamsByStreamKey([]stream.Stream{newStream("1", "S1", "T1")}); len(errs) != 0 {
+		t.Fatalf("create: %v", errs)
+	}
+	errs := r.CreatStreamsByStreamKey([]stream.Stream{newStream("1", "S1", "T9")})
+	if len(errs) != 1 || !errors.Is(errs[0], repository.ErrConflict) || itemStatus(errs[0]) != http.StatusConflict {
+		t.Fatalf("create twice = %v, want a conflict", errs)
+	}
+}
+
+func TestETag(t *testing.T) {
+	r := NewRepository()
+	if errs := r.CreatStreamsByStreamKey([]stream.Stream{newStream("1", "S1", "T1")}); len(errs) != 0 {
+		t.Fatalf("create: %v", errs)
+	}
+	read := func() stream.Stream {
+		got, err := r.GetStreamByStreamIdAndSiteCode("T1", "S1")
+		if err != nil || len(got) != 1 {
+			t.Fatalf("get = %v, %v", got, err)
+		}
+		return got[0]
+	}
+
+	first := read()
+	if first.ETag == "" {
+		t.Fatal("a stored stream has no ETag")
+	}
+	first.StreamName = "v2"
+	if errs := r.UpdateStreamsByStreamKey([]stream.Stream{first}); len(errs) != 0 {
+		t.Fatalf("update: %v", errs)
+	}
+	second := read()
+	if second.ETag == first.ETag || second.StreamName != "v2" {
+		t.Fatalf("update gave %s %q, want a new ETag and v2", second.ETag, second.StreamName)
+	}
+
+	tests := []struct {
+		name  string
+		write func(streams []stream.Stream) []error
+	}{
+		{"update", r.UpdateStreamsByStreamKey},
+		{"delete", r.DeleteStreamsByStreamKey},
+		{"batch replace", func(streams []stream.Stream) []error {
+			return r.WriteBatchedStreamsByStreamKey(repository.BatchReplace, streams)
+		}},
+	}
+	for _, tt := range tests {
+		// first carries the ETag of the first version
+		errs := tt.write([]stream.Stream{first})
+		if len(errs) != 1 || !errors.Is(errs[0], repository.ErrPreconditionFailed) || itemStatus(errs[0]) != http.StatusPreconditionFailed {
+			t.Errorf("%s with a stale ETag = %v, want a precondition failure", tt.name, errs)
+		}
+	}
+	if got := read(); got.ETag != second.ETag {
+		t.Errorf("a stale write changed the stream")
+	}
+
+	missing := newStream("9", "S1", "T9")
+	if errs := r.UpdateStreamsByStreamKey([]stream.Stream{missing}); len(errs) != 1 || !errors.Is(errs[0], repository.ErrNotFound) {
+		t.Errorf("update of a missing stream = %v, want not found", errs)
+	}
+	if errs := r.DeleteStreamsByStreamKey([]stream.Stream{second}); len(errs) != 0 {
+		t.Errorf("delete: %v", errs)
+	}
+}
+
+func TestBatchRollsBackPartition(t *testing.T) {
+	r := NewRepository()
+	if errs := r.CreatStreamsByStreamKey([]stream.Stream{newStream("2", "S1", "T2")}); len(errs) != 0 {
+		t.Fatalf("create: %v", errs)
+	}
+
+	errs := r.WriteBatchedStreamsByStreamKey(repository.BatchCreate, []stream.Stream{
+		newStream("1", "S1", "T1"),
+		newStream("2", "S1", "T2"), // already stored
+		newStream("3", "S1", "T3"),
+		newStream("4", "S2", "T4"), // other partition, written
+	})
+	statuses := make(map[string]int)
+	for _, err := range errs {
+		var itemErr *repository.ItemError
+		if !errors.As(err, &itemErr) {
+			t.Fatalf("batch error %v is not an ItemError", err)
+		}
+		statuses[itemErr.ID] = itemErr.StatusCode
+	}
+	want := map[string]int{"1": http.StatusFailedDependency, "2": http.StatusConflict, "3": http.StatusFailedDependency}
+	if len(statuses) != len(want) {
+		t.Fatalf("batch statuses = %v, want %v", statuses, want)
+	}
+	for id, status := range want {
+		if statuses[id] != status {
+			t.Errorf("status of %s = %d, want %d", id, statuses[id], status)
+		}
+	}
+
+	s1, _ := r.StreamsBySiteCode("S1")
+	s2, _ := r.StreamsBySiteCode("S2")
+	if len(s1) != 1 || len(s2) != 1 {
+		t.Errorf("after the batch S1 has %d streams and S2 %d, want 1 and 1", len(s1), len(s2))
+	}
+}
+
+func TestHistory(t *testing.T) {
+	r := NewRepository()
+	s := newStream("1", "S1", "T1")
+	if errs := r.CreatStreamsByStreamKey([]stream.Stream{s}); len(errs) != 0 {
+		t.Fatalf("create: %v", errs)
+	}
+	v2 := s
+	v2.Version = 2
+	if errs := r.CreateHistory([]stream.History{stream.NewHistory(v2), stream.NewHistory(s), stream.NewHistory(s)}); len(errs) != 0 {
+		t.Fatalf("history: %v", errs)
+	}
+
+	entries, err := r.GetHistory("1", "S1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Version != 1 || entries[1].Version != 2 {
+		t.Fatalf("history = %+v, want versions 1 and 2", entries)
+	}
+	if restored := entries[0].Restore(); restored.ID != "1" || restored.RegistryType != stream.RegistryTypeStream {
+		t.Errorf("restored %s %s, want the stream ID and type", restored.ID, restored.RegistryType)
+	}
+	// history entries are not streams
+	if got, _ := r.GetStreamByStreamIdAndSiteCode("T1", "S1"); len(got) != 1 {
+		t.Errorf("get returned %d streams, want 1", len(got))
+	}
+}
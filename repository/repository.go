@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"githb.com/Go-routine-4595/stream-ingest/domain/stream"
+	"githb.com/Go-routine-4595/stream-ingest/model"
+)
+
+var (
+	// ErrNotFound is returned when a stream to replace does not exist in its partition.
+	ErrNotFound = errors.New("stream not found")
+	// ErrConflict is returned when a stream with the same ID already exists in its partition.
+	ErrConflict = errors.New("stream already exists")
+	// ErrPreconditionFailed is returned when a stream to replace changed since it was read (ETag mismatch).
+	ErrPreconditionFailed = errors.New("stream modified since it was read")
+)
+
+// StreamRepository is the storage used by the commands to look up and persist streams.
+// Streams are partitioned by SiteCode and their ID is unique within a partition.
+type StreamRepository interface {
+	// GetStreamByStreamIdAndSiteCode returns every stream of the siteCode partition having the given sensorId.
+	GetStreamByStreamIdAndSiteCode(sensorId string, siteCode string) ([]stream.Stream, error)
+	// QueryStreams returns the streams matching the filter sorted by SiteCode then SensorID.
+	QueryStreams(filter StreamFilter) ([]stream.Stream, error)
+	// CreatStreamsByStreamKey creates the streams one by one and returns an error for each failure.
+	CreatStreamsByStreamKey(streams []stream.Stream) []error
+	// UpdateStreamsByStreamKey replaces the streams one by one and returns an error for each failure.
+	// A stream carrying an ETag is only replaced if the stored document still has this ETag,
+	// otherwise the error wraps ErrPreconditionFailed.
+	UpdateStreamsByStreamKey(streams []stream.Stream) []error
+	// DeleteStreamsByStreamKey deletes the streams one by one and returns an error for each failure.
+	// A stream carrying an ETag is only deleted if the stored document still has this ETag,
+	// otherwise the error wraps ErrPreconditionFailed.
+	DeleteStreamsByStreamKey(streams []stream.Stream) []error
+	// WriteBatchedStreamsByStreamKey writes the streams grouped by partition in transactional batches.
+	// Failures are reported as *ItemError, one per stream not written.
+	WriteBatchedStreamsByStreamKey(op BatchOperation, streams []stream.Stream) []error
+	// CreateHistory stores the previous versions of streams, replacing an entry with the same ID.
+	// Failures are reported as *ItemError, one per entry not written.
+	CreateHistory(entries []stream.History) []error
+	// GetHistory returns the previous versions of the stream streamID of the siteCode partition sorted by Version.
+	GetHistory(streamID string, siteCode string) ([]stream.History, error)
+	// TagNames returns the sorted names of the tags held by at least one stream.
+	TagNames() ([]string, error)
+	Close()
+}
+
+// BatchOperation is the write applied to every stream of a transactional batch.
+type BatchOperation string
+
+const (
+	BatchCreate  BatchOperation = "create"
+	BatchUpsert  BatchOperation = "upsert"
+	BatchReplace BatchOperation = "replace"
+)
+
+// ItemError is the failure to write one stream. It identifies the stream so the
+// caller can relate it to where it came from.
+type ItemError struct {
+	ID         string
+	SensorID   string
+	SiteCode   string
+	StatusCode int // HTTP status of the operation, 0 when unknown
+	Err        error
+}
+
+func NewItemError(s stream.Stream, statusCode int, err error) *ItemError {
+	return &ItemError{ID: s.ID, SensorID: s.SensorID, SiteCode: s.SiteCode, StatusCode: statusCode, Err: err}
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("stream %s (id %s, site %s): %v", e.SensorID, e.ID, e.SiteCode, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// StreamFilter selects streams. Empty fields match everything and
+// a stream must hold every tag of Tags to match.
+type StreamFilter struct {
+	SiteCode string
+	Process  string
+	Tags     []model.Tag
+}
+
+// Match reports whether the stream is selected by the filter.
+func (f StreamFilter) Match(s stream.Stream) bool {
+	if s.RegistryType != stream.RegistryTypeStream {
+		return false
+	}
+	if f.SiteCode != "" && s.SiteCode != f.SiteCode {
+		return false
+	}
+	if f.Process != "" && s.Process != f.Process {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !s.Tags.Contains(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// SortStreams sorts the streams by SiteCode then SensorID.
+func SortStreams(streams []stream.Stream) {
+	sort.Slice(streams, func(i, j int) bool {
+		if streams[i].SiteCode != streams[j].SiteCode {
+			return streams[i].SiteCode < streams[j].SiteCode
+		}
+		return streams[i].SensorID < streams[j].SensorID
+	})
+}
+
+// UniqueNames sorts the names and removes the duplicates.
+func UniqueNames(names []string) []string {
+	sort.Strings(names)
+	res := make([]string, 0, len(names))
+	for i, name := range names {
+		if i == 0 || names[i-1] != name {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// SortHistory sorts the history entries by Version.
+func SortHistory(entries []stream.History) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Version < entries[j].Version
+	})
+}
+
+// Flusher is implemented by the repositories keeping their writes in memory until flushed.
+type Flusher interface {
+	// Flush persists the writes made since the last flush and returns an error for each failure.
+	Flush() []error
+}
+
+// StatsReporter is implemented by the repositories measuring the requests they send.
+type StatsReporter interface {
+	Stats() Stats
+}
+
+// Stats sums up the requests sent to a repository.
+type Stats struct {
+	Operations    int         // logical operations (one per stream read or written)
+	Attempts      int         // requests sent, retries included
+	RequestCharge float64     // request units consumed
+	Retried       []ItemStats // operations that needed more than one attempt
+}
+
+// ItemStats describes one operation of the repository.
+type ItemStats struct {
+	Op            string
+	ID            string
+	SiteCode      string
+	Attempts      int
+	RequestCharge float64
+	Err           error
+}